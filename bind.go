@@ -0,0 +1,239 @@
+// bind.go 用 reflect.MakeFunc 把一个查询表达式绑定成强类型的函数。函数签名在绑定的时候
+// 检查一次，SQL 也只生成和 Prepare 一次，调用的时候只剩下传参和加载结果
+package pgears
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/Dwarfartisan/pgears/exp"
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	resultType  = reflect.TypeOf((*sql.Result)(nil)).Elem()
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+)
+
+// 绑定函数返回结果的方式
+const (
+	bindExec = iota
+	bindOne
+	bindMany
+)
+
+// binding 是绑定时分析出来的全部信息
+type binding struct {
+	db     *Engine
+	stmt   *sql.Stmt
+	sql    string
+	fnType reflect.Type
+	// withCtx 表示第一个参数是 context.Context
+	withCtx bool
+	mode    int
+	// withRes 表示 bindExec 的函数要返回 sql.Result
+	withRes bool
+	// elem 是结果的元素类型， ptr 表示结果（或者切片的元素）是它的指针
+	elem     reflect.Type
+	ptr      bool
+	table    *DbTable
+	typeName string
+}
+
+// Bind 把 expr 绑定到 fn 指向的函数变量上，例如
+//
+//	var GetAccount func(ctx context.Context, uid string) (*Account, error)
+//	err := engine.Bind(&GetAccount, exp.Select(...).Where(exp.Equal(..., exp.Arg(1))))
+//
+// 函数的第一个参数可以是 context.Context ，其余参数依次对应 expr 中的 $1 、 $2 ……，数量
+// 必须跟 expr 中最大的参数序号一致。返回值的最后一个必须是 error ，前面可以是：
+//
+//	T 或 *T    加载第一行，没有结果时返回 NotFound
+//	[]T 或 []*T  加载所有行
+//	sql.Result  执行不返回结果集的语句，只返回 error 也可以
+//
+// T 是结构的话必须是注册过的类型，按字段名加载，并且会调用 AfterFetch 钩子；其它类型
+// （int64 、 string 、 time.Time 、实现了 sql.Scanner 的类型等）取每行的第一列。
+// 签名不对或者 Prepare 失败都在这里返回 error ，绑定好的函数总是在 Engine 的连接池上执行
+func (e *Engine) Bind(fn interface{}, expr exp.Exp) error {
+	var ptr = reflect.ValueOf(fn)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != reflect.Func {
+		return fmt.Errorf("Bind needs a pointer to a func variable, got %T", fn)
+	}
+	var b = &binding{db: e, fnType: ptr.Elem().Type()}
+	if err := b.check(exp.MaxOrder(expr)); err != nil {
+		return fmt.Errorf("can't bind %v: %v", b.fnType, err)
+	}
+	var parser = NewParser(e)
+	b.sql = expr.Eval(parser)
+	var stmt, err = e.prepare(e, "Bind", b.elem, b.sql)
+	if err != nil {
+		return err
+	}
+	b.stmt = stmt
+	ptr.Elem().Set(reflect.MakeFunc(b.fnType, b.call))
+	return nil
+}
+
+// check 检查函数签名， params 是表达式的参数个数
+func (b *binding) check(params int) error {
+	var typ = b.fnType
+	if typ.IsVariadic() {
+		return errors.New("variadic func is not supported")
+	}
+	var in = typ.NumIn()
+	if in > 0 && typ.In(0) == contextType {
+		b.withCtx = true
+		in--
+	}
+	if in != params {
+		return fmt.Errorf("expression has %d parameters but func takes %d", params, in)
+	}
+	for i := typ.NumIn() - in; i < typ.NumIn(); i++ {
+		switch typ.In(i).Kind() {
+		case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+			return fmt.Errorf("parameter %d of type %v can't be a query argument", i, typ.In(i))
+		}
+	}
+
+	switch {
+	case typ.NumOut() == 1 && typ.Out(0) == errorType:
+		b.mode = bindExec
+		return nil
+	case typ.NumOut() != 2 || typ.Out(1) != errorType:
+		return errors.New("func must return (result, error) or error")
+	case typ.Out(0) == resultType:
+		b.mode = bindExec
+		b.withRes = true
+		return nil
+	}
+	var out = typ.Out(0)
+	b.mode = bindOne
+	if out.Kind() == reflect.Slice && out.Elem().Kind() != reflect.Uint8 {
+		b.mode = bindMany
+		out = out.Elem()
+	}
+	b.elem = out
+	if out.Kind() == reflect.Ptr {
+		b.ptr = true
+		b.elem = out.Elem()
+	}
+	b.typeName = b.elem.String()
+	if table, ok := b.db.gomap[b.elem]; ok {
+		b.table = table
+		b.typeName = fullGoName(b.elem)
+		return nil
+	}
+	if b.elem.Kind() == reflect.Struct && !isScalarStruct(b.elem) {
+		return fmt.Errorf("%v is't a regiested type", fullGoName(b.elem))
+	}
+	return nil
+}
+
+// isScalarStruct 判断结构类型能不能直接作为一列来 Scan ，例如 time.Time 和 sql.NullString
+func isScalarStruct(typ reflect.Type) bool {
+	return typ == reflect.TypeOf(time.Time{}) || reflect.PtrTo(typ).Implements(scannerType)
+}
+
+// call 是绑定函数的实现
+func (b *binding) call(in []reflect.Value) []reflect.Value {
+	var ctx = b.db.Context()
+	if b.withCtx {
+		if c, ok := in[0].Interface().(context.Context); ok && c != nil {
+			ctx = c
+		}
+		in = in[1:]
+	}
+	var args = make([]interface{}, 0, len(in))
+	for _, val := range in {
+		args = append(args, val.Interface())
+	}
+
+	if b.mode == bindExec {
+		var call = b.newCall(ctx, KindExec, args)
+		var err = b.db.invoke(call)
+		if b.withRes {
+			var res = reflect.New(resultType).Elem()
+			if call.Result != nil {
+				res.Set(reflect.ValueOf(call.Result))
+			}
+			return []reflect.Value{res, errValue(err)}
+		}
+		return []reflect.Value{errValue(err)}
+	}
+
+	var out = b.fnType.Out(0)
+	var call = b.newCall(ctx, KindQuery, args)
+	if err := b.db.invoke(call); err != nil {
+		return []reflect.Value{reflect.Zero(out), errValue(err)}
+	}
+	var rows = call.Rows
+	defer rows.Close()
+	var result = reflect.Zero(out)
+	if b.mode == bindMany {
+		result = reflect.MakeSlice(out, 0, 0)
+	}
+	for rows.Next() {
+		var item, err = b.load(ctx, rows)
+		if err != nil {
+			return []reflect.Value{reflect.Zero(out), errValue(err)}
+		}
+		if !b.ptr {
+			item = item.Elem()
+		}
+		if b.mode == bindOne {
+			return []reflect.Value{item, errValue(nil)}
+		}
+		result = reflect.Append(result, item)
+	}
+	if err := rows.Err(); err != nil {
+		return []reflect.Value{reflect.Zero(out), errValue(err)}
+	}
+	if b.mode == bindOne {
+		return []reflect.Value{result, errValue(NewNotFound(b.typeName))}
+	}
+	return []reflect.Value{result, errValue(nil)}
+}
+
+// newCall 构造一次经过拦截器链的调用，语句是绑定时 Prepare 好的
+func (b *binding) newCall(ctx context.Context, kind string, args []interface{}) *Call {
+	var call = b.db.newCall(nil, kind, "Bind", b.elem, b.sql, args, nil)
+	call.Context = ctx
+	call.stmt = b.stmt
+	return call
+}
+
+// load 把当前行加载到一个新的 *T 里
+func (b *binding) load(ctx context.Context, rows *sql.Rows) (reflect.Value, error) {
+	var item = reflect.New(b.elem)
+	if b.table == nil {
+		// 跟 ResultSet.Scalar 一样，多出来的列扔掉
+		cols, err := rows.Columns()
+		if err != nil {
+			return item, err
+		}
+		var slots = []interface{}{item.Interface()}
+		for i := 1; i < len(cols); i++ {
+			slots = append(slots, new(interface{}))
+		}
+		return item, rows.Scan(slots...)
+	}
+	if err := b.table.all(rows, item.Interface()); err != nil {
+		return item, err
+	}
+	return item, afterFetch(ctx, item.Interface(), b.db)
+}
+
+// errValue 把 error 包装成返回值，nil 也要带上 error 类型
+func errValue(err error) reflect.Value {
+	var val = reflect.New(errorType).Elem()
+	if err != nil {
+		val.Set(reflect.ValueOf(err))
+	}
+	return val
+}
@@ -0,0 +1,30 @@
+package pgears
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Dwarfartisan/pgears/exp"
+)
+
+func TestBindOutOfOrder(t *testing.T) {
+	var engine = sqliteEngine(t)
+	if err := engine.Insert(&pair{ID: 1, Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	var table = exp.TableAs(fullGoName(reflect.TypeOf(pair{})), "pair")
+	// $2 在 $1 前面出现，SQLite 也要按序号绑定
+	var get func(id int, name string) (*pair, error)
+	var expr = exp.Select(table.Field("ID"), table.Field("Name")).From(table).
+		Where(exp.And(exp.Equal(table.Field("Name"), exp.Arg(2)), exp.Equal(table.Field("ID"), exp.Arg(1))))
+	if err := engine.Bind(&get, expr); err != nil {
+		t.Fatal(err)
+	}
+	obj, err := get(1, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if obj.ID != 1 || obj.Name != "first" {
+		t.Errorf("expect {1 first} but got %+v", obj)
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"sort"
+	"strings"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+// field 是生成的结构中的一个字段
+type field struct {
+	*Column
+	GoName string
+	GoType string
+	Json   bool
+}
+
+// model 是一张表对应的结构
+type model struct {
+	*Table
+	GoName string
+	Fields []*field
+}
+
+func (m *model) pks() []*field {
+	var ret = make([]*field, 0)
+	for _, f := range m.Fields {
+		if f.PK {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// generate 生成 tables 对应的 Go 源码，已经 gofmt 过
+func generate(pkg string, tables []*Table) ([]byte, error) {
+	var models = make([]*model, 0, len(tables))
+	var imports = map[string]bool{"github.com/Dwarfartisan/pgears": true}
+	for _, table := range tables {
+		var m = &model{Table: table, GoName: goName(table.Name)}
+		for _, col := range table.Columns {
+			var typ, json, imp = goType(col)
+			if imp != "" {
+				imports[imp] = true
+			}
+			if json {
+				imports["encoding/json"] = true
+			}
+			m.Fields = append(m.Fields, &field{Column: col, GoName: goName(col.Name), GoType: typ, Json: json})
+		}
+		models = append(models, m)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by pgears-gen. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	writeImports(&buf, imports)
+
+	buf.WriteString("// Register 把生成的结构注册到 engine\n")
+	buf.WriteString("func Register(engine *pgears.Engine) {\n")
+	for _, m := range models {
+		fmt.Fprintf(&buf, "\tengine.MapStructTo(&%s{}, %q)\n", m.GoName, m.Name)
+	}
+	buf.WriteString("}\n\n")
+	buf.WriteString("// scanner 是 *sql.Row 和 *sql.Rows 共有的 Scan 方法\n")
+	buf.WriteString("type scanner interface {\n\tScan(dest ...interface{}) error\n}\n")
+
+	for _, m := range models {
+		writeModel(&buf, m)
+	}
+	return formatSource(&buf)
+}
+
+// writeImports 生成 import 块，标准库在前，其它包在后，中间空一行
+func writeImports(buf *bytes.Buffer, imports map[string]bool) {
+	var paths = make([]string, 0, len(imports))
+	for path := range imports {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		var si, sj = isStd(paths[i]), isStd(paths[j])
+		if si != sj {
+			return si
+		}
+		return paths[i] < paths[j]
+	})
+	buf.WriteString("import (\n")
+	for idx, path := range paths {
+		if idx > 0 && isStd(paths[idx-1]) && !isStd(path) {
+			buf.WriteString("\n")
+		}
+		fmt.Fprintf(buf, "\t%q\n", path)
+	}
+	buf.WriteString(")\n\n")
+}
+
+// formatSource 对生成的代码做 gofmt ，格式化失败说明生成的代码有语法错误
+func formatSource(buf *bytes.Buffer) ([]byte, error) {
+	var src, err = format.Source(buf.Bytes())
+	if err != nil {
+		return buf.Bytes(), fmt.Errorf("generated code is invalid: %v", err)
+	}
+	return src, nil
+}
+
+// writeModel 生成结构定义和它的 CRUD 函数。这些函数直接写出 SQL 和 Scan 的目标，调用时不用反射
+func writeModel(buf *bytes.Buffer, m *model) {
+	var lower = strings.ToLower(m.GoName[:1]) + m.GoName[1:]
+	var names = make([]string, 0, len(m.Fields))
+	for _, f := range m.Fields {
+		names = append(names, f.Name)
+	}
+
+	fmt.Fprintf(buf, "\n// %s 对应数据表 %s\n", m.GoName, m.Name)
+	fmt.Fprintf(buf, "type %s struct {\n", m.GoName)
+	for _, f := range m.Fields {
+		var tag = fmt.Sprintf("field:%q", f.Name)
+		if f.PK {
+			tag += ` pk:"true"`
+		}
+		if f.DbGen {
+			tag += ` dbgen:"true"`
+		}
+		if f.Json {
+			tag += ` jsonto:"any"`
+		}
+		fmt.Fprintf(buf, "\t%s %s `%s`\n", f.GoName, f.GoType, tag)
+	}
+	buf.WriteString("}\n\n")
+	fmt.Fprintf(buf, "const %sColumns = %q\n\n", lower, strings.Join(names, ", "))
+
+	// scan
+	fmt.Fprintf(buf, "// scan%s 按 %sColumns 的顺序读取一行\n", m.GoName, lower)
+	fmt.Fprintf(buf, "func scan%s(row scanner) (*%s, error) {\n\tvar obj %s\n", m.GoName, m.GoName, m.GoName)
+	var dests = make([]string, 0, len(m.Fields))
+	for _, f := range m.Fields {
+		if f.Json {
+			fmt.Fprintf(buf, "\tvar %s []byte\n", jsonVar(f))
+			dests = append(dests, "&"+jsonVar(f))
+		} else {
+			dests = append(dests, "&obj."+f.GoName)
+		}
+	}
+	fmt.Fprintf(buf, "\tif err := row.Scan(%s); err != nil {\n\t\treturn nil, err\n\t}\n", strings.Join(dests, ", "))
+	for _, f := range m.Fields {
+		if f.Json {
+			fmt.Fprintf(buf, "\tif %s != nil {\n\t\tif err := json.Unmarshal(%s, &obj.%s); err != nil {\n\t\t\treturn nil, err\n\t\t}\n\t}\n",
+				jsonVar(f), jsonVar(f), f.GoName)
+		}
+	}
+	buf.WriteString("\treturn &obj, nil\n}\n\n")
+
+	// list
+	fmt.Fprintf(buf, "// List%s 读取 %s 中的全部行\n", m.GoName, m.Name)
+	fmt.Fprintf(buf, "func List%s(db pgears.Executor) ([]*%s, error) {\n", m.GoName, m.GoName)
+	fmt.Fprintf(buf, "\trows, err := db.Query(\"SELECT \" + %sColumns + \" FROM %s\")\n", lower, m.Name)
+	buf.WriteString("\tif err != nil {\n\t\treturn nil, err\n\t}\n\tdefer rows.Close()\n")
+	fmt.Fprintf(buf, "\tvar ret = make([]*%s, 0)\n\tfor rows.Next() {\n", m.GoName)
+	fmt.Fprintf(buf, "\t\tobj, err := scan%s(rows)\n\t\tif err != nil {\n\t\t\treturn nil, err\n\t\t}\n\t\tret = append(ret, obj)\n\t}\n", m.GoName)
+	buf.WriteString("\treturn ret, rows.Err()\n}\n\n")
+
+	var pks = m.pks()
+	if len(pks) > 0 {
+		var by, params, conds, args = pkParts(pks, 1)
+		fmt.Fprintf(buf, "// Get%sBy%s 按主键读取一行，没有的话返回 sql.ErrNoRows\n", m.GoName, by)
+		fmt.Fprintf(buf, "func Get%sBy%s(db pgears.Executor, %s) (*%s, error) {\n", m.GoName, by, params, m.GoName)
+		fmt.Fprintf(buf, "\treturn scan%s(db.QueryRow(\"SELECT \" + %sColumns + \" FROM %s WHERE %s\", %s))\n}\n\n",
+			m.GoName, lower, m.Name, conds, args)
+	}
+
+	writeInsert(buf, m)
+
+	var others = make([]*field, 0)
+	for _, f := range m.Fields {
+		if !f.PK {
+			others = append(others, f)
+		}
+	}
+	if len(pks) > 0 && len(others) > 0 {
+		fmt.Fprintf(buf, "// Update%s 按主键更新 obj 的所有非主键字段，返回受影响的行数\n", m.GoName)
+		fmt.Fprintf(buf, "func Update%s(db pgears.Executor, obj *%s) (int64, error) {\n", m.GoName, m.GoName)
+		var sets = make([]string, 0, len(others))
+		var args = make([]string, 0, len(m.Fields))
+		for idx, f := range others {
+			sets = append(sets, fmt.Sprintf("%s = $%d", f.Name, idx+1))
+			args = append(args, writeArg(buf, f, "0"))
+		}
+		var conds = make([]string, 0, len(pks))
+		for idx, f := range pks {
+			conds = append(conds, fmt.Sprintf("%s = $%d", f.Name, len(others)+idx+1))
+			args = append(args, "obj."+f.GoName)
+		}
+		fmt.Fprintf(buf, "\tres, err := db.Exec(\"UPDATE %s SET %s WHERE %s\", %s)\n", m.Name,
+			strings.Join(sets, ", "), strings.Join(conds, " AND "), strings.Join(args, ", "))
+		buf.WriteString("\tif err != nil {\n\t\treturn 0, err\n\t}\n\treturn res.RowsAffected()\n}\n\n")
+	}
+
+	if len(pks) > 0 {
+		var _, params, conds, args = pkParts(pks, 1)
+		fmt.Fprintf(buf, "// Delete%s 按主键删除一行，返回受影响的行数\n", m.GoName)
+		fmt.Fprintf(buf, "func Delete%s(db pgears.Executor, %s) (int64, error) {\n", m.GoName, params)
+		fmt.Fprintf(buf, "\tres, err := db.Exec(\"DELETE FROM %s WHERE %s\", %s)\n", m.Name, conds, args)
+		buf.WriteString("\tif err != nil {\n\t\treturn 0, err\n\t}\n\treturn res.RowsAffected()\n}\n")
+	}
+}
+
+// writeInsert 生成 Insert 函数， dbgen 字段不插入，而是通过 RETURNING 写回 obj
+func writeInsert(buf *bytes.Buffer, m *model) {
+	var cols, marks, args, returning, dests []string
+	fmt.Fprintf(buf, "// Insert%s 插入 obj ，数据库生成的字段通过 RETURNING 写回 obj\n", m.GoName)
+	fmt.Fprintf(buf, "func Insert%s(db pgears.Executor, obj *%s) error {\n", m.GoName, m.GoName)
+	for _, f := range m.Fields {
+		if f.DbGen {
+			returning = append(returning, f.Name)
+			dests = append(dests, "&obj."+f.GoName)
+			continue
+		}
+		cols = append(cols, f.Name)
+		marks = append(marks, fmt.Sprintf("$%d", len(cols)))
+		args = append(args, writeArg(buf, f, ""))
+	}
+	var query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", m.Name, strings.Join(cols, ", "), strings.Join(marks, ", "))
+	if len(cols) == 0 {
+		query = fmt.Sprintf("INSERT INTO %s DEFAULT VALUES", m.Name)
+	}
+	var argList = ""
+	if len(args) > 0 {
+		argList = ", " + strings.Join(args, ", ")
+	}
+	if len(returning) > 0 {
+		fmt.Fprintf(buf, "\treturn db.QueryRow(%q%s).Scan(%s)\n}\n\n",
+			query+" RETURNING "+strings.Join(returning, ", "), argList, strings.Join(dests, ", "))
+	} else {
+		fmt.Fprintf(buf, "\t_, err := db.Exec(%q%s)\n\treturn err\n}\n\n", query, argList)
+	}
+}
+
+// writeArg 给出字段作为参数时的表达式， json 字段要先序列化， zero 是出错时额外返回的零值
+func writeArg(buf *bytes.Buffer, f *field, zero string) string {
+	if !f.Json {
+		return "obj." + f.GoName
+	}
+	var ret = "err"
+	if zero != "" {
+		ret = zero + ", err"
+	}
+	var arg = jsonVar(f)
+	fmt.Fprintf(buf, "\tvar %s interface{}\n\tif obj.%s != nil {\n", arg, f.GoName)
+	fmt.Fprintf(buf, "\t\tdata, err := json.Marshal(obj.%s)\n\t\tif err != nil {\n\t\t\treturn %s\n\t\t}\n\t\t%s = data\n\t}\n",
+		f.GoName, ret, arg)
+	return arg
+}
+
+// pkParts 生成主键相关的函数名后缀、参数列表、条件和实参
+func pkParts(pks []*field, start int) (by, params, conds, args string) {
+	var bys, ps, cs, as []string
+	for idx, f := range pks {
+		var param = paramName(f.GoName)
+		bys = append(bys, f.GoName)
+		ps = append(ps, param+" "+strings.TrimPrefix(f.GoType, "*"))
+		cs = append(cs, fmt.Sprintf("%s = $%d", f.Name, start+idx))
+		as = append(as, param)
+	}
+	return strings.Join(bys, "And"), strings.Join(ps, ", "), strings.Join(cs, " AND "), strings.Join(as, ", ")
+}
+
+func isStd(path string) bool {
+	return !strings.Contains(strings.SplitN(path, "/", 2)[0], ".")
+}
+
+// reserved 是生成的函数体里用到的局部变量名
+var reserved = map[string]bool{"db": true, "obj": true, "res": true, "err": true, "rs": true, "ret": true, "item": true}
+
+// paramName 把导出名字变成参数名，避开关键字和生成代码里用到的名字
+func paramName(name string) string {
+	var param = strings.ToLower(name[:1]) + name[1:]
+	if token.IsKeyword(param) || reserved[param] {
+		param += "_"
+	}
+	return param
+}
+
+func jsonVar(f *field) string {
+	return strings.ToLower(f.GoName[:1]) + f.GoName[1:] + "JSON"
+}
+
+// goName 把 snake_case 的数据库名字转成 Go 的导出名字
+func goName(name string) string {
+	var parts = strings.FieldsFunc(name, func(r rune) bool {
+		return r == '_' || r == '-' || r == ' ' || r == '.'
+	})
+	var ret = ""
+	for _, part := range parts {
+		ret += strings.ToUpper(part[:1]) + part[1:]
+	}
+	if ret == "" || (ret[0] >= '0' && ret[0] <= '9') {
+		ret = "X" + ret
+	}
+	return ret
+}
+
+// goType 把字段类型映射成 Go 类型，可以为空的字段用指针。返回值 json 表示要用 jsonto 存取，
+// imp 是类型需要的 import
+func goType(col *Column) (typ string, json bool, imp string) {
+	var db = strings.ToLower(strings.TrimSpace(col.DbType))
+	if idx := strings.Index(db, "("); idx >= 0 {
+		db = strings.TrimSpace(db[:idx])
+	}
+	if strings.HasSuffix(db, "[]") {
+		var elem, _, _ = goType(&Column{DbType: strings.TrimSuffix(db, "[]"), NotNull: true})
+		switch elem {
+		case "int16", "int32", "int64":
+			return "pq.Int64Array", false, "github.com/lib/pq"
+		case "float32", "float64":
+			return "pq.Float64Array", false, "github.com/lib/pq"
+		case "bool":
+			return "pq.BoolArray", false, "github.com/lib/pq"
+		case "[]byte":
+			return "pq.ByteaArray", false, "github.com/lib/pq"
+		}
+		return "pq.StringArray", false, "github.com/lib/pq"
+	}
+	switch {
+	case db == "json" || db == "jsonb":
+		// 字段里可能是数组或者标量，不一定是对象，所以不用 map[string]interface{}
+		return "interface{}", true, ""
+	case db == "bytea" || db == "blob":
+		return "[]byte", false, ""
+	}
+	var base string
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		base = sqliteType(db)
+	} else {
+		base = pgType(db)
+	}
+	if base == "time.Time" {
+		imp = "time"
+	}
+	if !col.NotNull && !col.PK {
+		base = "*" + base
+	}
+	return base, false, imp
+}
+
+func pgType(db string) string {
+	switch db {
+	case "smallint", "int2", "smallserial":
+		return "int16"
+	case "integer", "int", "int4", "serial":
+		return "int32"
+	case "bigint", "int8", "bigserial":
+		return "int64"
+	case "real", "float4":
+		return "float32"
+	case "double precision", "float8", "numeric", "decimal":
+		return "float64"
+	case "boolean", "bool":
+		return "bool"
+	case "date", "timestamp with time zone", "timestamp without time zone", "timestamptz", "timestamp":
+		return "time.Time"
+	}
+	return "string"
+}
+
+// sqliteType 按 SQLite 的类型亲和性规则映射
+func sqliteType(db string) string {
+	switch {
+	case strings.Contains(db, "date") || strings.Contains(db, "time"):
+		return "time.Time"
+	case strings.Contains(db, "bool"):
+		return "bool"
+	case strings.Contains(db, "int"):
+		return "int64"
+	case strings.Contains(db, "char") || strings.Contains(db, "clob") || strings.Contains(db, "text"):
+		return "string"
+	case strings.Contains(db, "real") || strings.Contains(db, "floa") || strings.Contains(db, "doub"):
+		return "float64"
+	case db == "":
+		return "[]byte"
+	}
+	return "float64"
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/Dwarfartisan/pgears"
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+// 生成的代码有变化的时候，确认无误后用 go test -update 重写 testdata 里的 golden 文件
+var update = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// golden 比较 got 和 testdata 中的 name 文件
+func golden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	var path = filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("generated code differs from %s, run go test -update if the change is intended:\n%s", path, got)
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	engine, err := pgears.CreateEngine("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	engine.SetMaxOpenConns(1)
+	schema, err := os.ReadFile(filepath.Join("testdata", "schema.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+
+	tables, err := loadTables(engine.DB, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate("models", tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "models.golden", src)
+}
+
+func TestGenerateQueries(t *testing.T) {
+	var dir = filepath.Join("testdata", "queries")
+	pkg, structs, err := loadStructs(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries, err := loadQueries([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generateQueries(pkg, queries, structs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden(t, "queries.golden", src)
+}
+
+// modelsRun 是 TestGeneratedCode 放进 models.golden 所在包的测试，用生成的 CRUD 函数
+// 在 SQLite 上走一遍插入、读取和更新
+const modelsRun = `package models
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Dwarfartisan/pgears"
+)
+
+func TestRun(t *testing.T) {
+	engine, err := pgears.CreateEngine("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	engine.SetMaxOpenConns(1)
+	schema, err := os.ReadFile("../../schema.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.Exec(string(schema)); err != nil {
+		t.Fatal(err)
+	}
+	Register(engine)
+
+	var obj = Account{Name: "first", Balance: 1.5, CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+	if err := InsertAccount(engine, &obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.Id == 0 {
+		t.Fatal("expect id returned by InsertAccount")
+	}
+	obj.Name = "second"
+	if n, err := UpdateAccount(engine, &obj); err != nil || n != 1 {
+		t.Fatalf("expect 1 row updated but got %d, %v", n, err)
+	}
+	got, err := GetAccountById(engine, obj.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "second" || got.Balance != 1.5 || !got.CreatedAt.Equal(obj.CreatedAt) {
+		t.Errorf("expect %+v but got %+v", obj, got)
+	}
+}
+`
+
+// queriesRun 是 TestGeneratedCode 放进 queries.golden 所在包的测试， RenameAccount 里
+// $2 写在 $1 前面，顺便确认参数是按序号绑定的
+const queriesRun = `package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Dwarfartisan/pgears"
+)
+
+func TestRun(t *testing.T) {
+	engine, err := pgears.CreateEngine("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	engine.SetMaxOpenConns(1)
+	engine.MapStructTo(&Account{}, "account")
+	if _, err := engine.Exec("CREATE TABLE account (id INTEGER PRIMARY KEY, name TEXT NOT NULL, email TEXT, created_at TIMESTAMP NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := engine.Insert(&Account{ID: 1, Name: "first", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := RenameAccount(engine, 1, "second"); err != nil || n != 1 {
+		t.Fatalf("expect 1 row renamed but got %d, %v", n, err)
+	}
+	got, err := GetAccount(engine, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 1 || got.Name != "second" {
+		t.Errorf("expect account 1 renamed to second but got %+v", got)
+	}
+	if count, err := CountAccounts(engine); err != nil || count != 1 {
+		t.Errorf("expect 1 account but got %d, %v", count, err)
+	}
+}
+`
+
+// TestGeneratedCode 把 golden 文件里生成的代码连同一个小测试放进 testdata 下的临时包，
+// 用 go test 真正编译并且在 SQLite 上执行
+func TestGeneratedCode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("compiling generated code is slow")
+	}
+	var gobin, err = exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+	dir, err := os.MkdirTemp("testdata", "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var files = map[string]string{
+		"models/models.go":       filepath.Join("testdata", "models.golden"),
+		"queries/models.go":      filepath.Join("testdata", "queries", "models.go"),
+		"queries/queries.gen.go": filepath.Join("testdata", "queries.golden"),
+	}
+	for name, src := range files {
+		data, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, filepath.Join(dir, name), data)
+	}
+	writeFile(t, filepath.Join(dir, "models", "run_test.go"), []byte(modelsRun))
+	writeFile(t, filepath.Join(dir, "queries", "run_test.go"), []byte(queriesRun))
+
+	var pkgs = "./" + filepath.ToSlash(dir)
+	var cmd = exec.Command(gobin, "test", "-count=1", pkgs+"/models", pkgs+"/queries")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated code failed: %v\n%s", err, out)
+	}
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGenerateOnly(t *testing.T) {
+	engine, err := pgears.CreateEngine("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	engine.SetMaxOpenConns(1)
+	if _, err := engine.Exec("CREATE TABLE a (id INTEGER PRIMARY KEY); CREATE TABLE b (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	tables, err := loadTables(engine.DB, []string{"b", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0].Name != "b" {
+		t.Errorf("expect only table b but got %v", tables)
+	}
+}
+
+func TestGoType(t *testing.T) {
+	dbdriver.Sqltype = dbdriver.DB_SQLITE
+	var cases = []struct {
+		col  Column
+		typ  string
+		json bool
+	}{
+		{Column{DbType: "jsonb", NotNull: true}, "interface{}", true},
+		{Column{DbType: "json"}, "interface{}", true},
+		{Column{DbType: "text"}, "*string", false},
+		{Column{DbType: "integer", PK: true}, "int64", false},
+		{Column{DbType: "blob"}, "[]byte", false},
+	}
+	for _, c := range cases {
+		var typ, json, _ = goType(&c.col)
+		if typ != c.typ || json != c.json {
+			t.Errorf("%s: expect %s (json %v) but got %s (json %v)", c.col.DbType, c.typ, c.json, typ, json)
+		}
+	}
+}
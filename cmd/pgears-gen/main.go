@@ -0,0 +1,123 @@
+// pgears-gen 从已有的数据库生成 pgears 用的结构和不需要反射的 CRUD 函数
+//
+//	pgears-gen [-url URL] [-pkg NAME] [-out FILE] [-tables a,b]
+//
+// URL 跟 pgears.CreateEngine 的格式一样，没有给出的话读取环境变量 DATABASE_URL 。
+// 生成的文件包括每张表的结构（带 field/pk/dbgen/jsonto 标签）、调用 MapStructTo 的 Register
+// 函数，以及 ListXxx 、 GetXxxByPk 、 InsertXxx 、 UpdateXxx 、 DeleteXxx 这些函数，
+// 它们接受 pgears.Executor ，所以 *pgears.Engine 、 *sql.DB 和 *sql.Tx 都可以用
+//
+//	pgears-gen queries [-pkg NAME] [-out FILE] [-models DIR] PATH...
+//
+// queries 子命令把 PATH （.sql 文件或者包含 .sql 文件的目录）中的命名查询编译成 Go 函数，
+// 写法见 Query 。生成的函数接受 pgears.Session ，所以 *pgears.Engine 和 *pgears.Tran 都可以用，
+// 结果是结构的话通过 ResultSet.Load 加载。结构从 DIR 中的 Go 源码读取（默认是 FILE
+// 所在的目录），没有 field 标签的字段按 SnakeCase 命名。查询结果中的字段如果在结构中没有映射，
+// 生成会失败。适合这样用：
+//
+//	//go:generate pgears-gen queries -out queries.gen.go queries
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Dwarfartisan/pgears"
+)
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "queries" {
+		if err := queriesMain(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "pgears-gen queries:", err)
+			os.Exit(1)
+		}
+		return
+	}
+	var url = flag.String("url", os.Getenv("DATABASE_URL"), "database url, like postgres://... or sqlite://...")
+	var pkg = flag.String("pkg", "models", "package name of the generated file")
+	var out = flag.String("out", "", "output file, default is stdout")
+	var tables = flag.String("tables", "", "comma separated tables to generate, default is all")
+	flag.Parse()
+	if err := run(*url, *pkg, *out, *tables); err != nil {
+		fmt.Fprintln(os.Stderr, "pgears-gen:", err)
+		os.Exit(1)
+	}
+}
+
+func run(url, pkg, out, only string) error {
+	if url == "" {
+		return fmt.Errorf("database url is required, use -url or DATABASE_URL")
+	}
+	engine, err := pgears.CreateEngine(url)
+	if err != nil {
+		return err
+	}
+	defer engine.Close()
+	var names []string
+	for _, name := range strings.Split(only, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	tables, err := loadTables(engine.DB, names)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		return fmt.Errorf("no table found")
+	}
+	src, err := generate(pkg, tables)
+	if err != nil {
+		return err
+	}
+	return writeOutput(out, src)
+}
+
+func queriesMain(args []string) error {
+	var flags = flag.NewFlagSet("queries", flag.ExitOnError)
+	var pkg = flags.String("pkg", os.Getenv("GOPACKAGE"), "package name of the generated file, default is the package in -models")
+	var out = flags.String("out", "", "output file, default is stdout")
+	var models = flags.String("models", "", "directory of the mapped structs, default is the directory of -out")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no sql file given")
+	}
+	var dir = *models
+	if dir == "" {
+		dir = "."
+		if *out != "" {
+			dir = filepath.Dir(*out)
+		}
+	}
+	name, structs, err := loadStructs(dir, *out)
+	if err != nil {
+		return err
+	}
+	if *pkg == "" {
+		*pkg = name
+	}
+	if *pkg == "" {
+		*pkg = "models"
+	}
+	queries, err := loadQueries(flags.Args())
+	if err != nil {
+		return err
+	}
+	src, err := generateQueries(*pkg, queries, structs)
+	if err != nil {
+		return err
+	}
+	return writeOutput(*out, src)
+}
+
+// writeOutput 把生成的代码写到文件， out 为空的时候写到标准输出
+func writeOutput(out string, src []byte) error {
+	if out == "" {
+		_, err := os.Stdout.Write(src)
+		return err
+	}
+	return os.WriteFile(out, src, 0644)
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// 查询的返回方式，跟注释里 :one 、 :many 这些写法一致
+const (
+	modeOne      = "one"
+	modeMany     = "many"
+	modeExec     = "exec"
+	modeExecRows = "execrows"
+)
+
+// Query 是 .sql 文件中的一条命名查询
+//
+//	-- name: ListActiveAccounts :many Account
+//	-- ListActiveAccounts 列出所有有效的账户
+//	-- param: status string
+//	SELECT uid, name FROM account WHERE status = $1;
+//
+// :one 和 :many 后面跟着结果的类型，可以是注册过的结构，也可以是 int64 、 time.Time
+// 这种单列的类型。 param 按 $1 、 $2 …… 的顺序声明参数，其余的注释行作为生成函数的文档
+type Query struct {
+	Name   string
+	Mode   string
+	Result string
+	Params []*Param
+	Doc    []string
+	SQL    string
+	// File 和 Line 是 name 注释所在的位置，用于报错
+	File string
+	Line int
+}
+
+// Param 是查询的一个参数
+type Param struct {
+	Name   string
+	GoType string
+}
+
+var (
+	nameRe  = regexp.MustCompile(`^--\s*name:\s*(\w+)\s+:(\w+)(?:\s+(\S+))?\s*$`)
+	paramRe = regexp.MustCompile(`^--\s*param:\s*(\w+)\s+(\S+)\s*$`)
+	argRe   = regexp.MustCompile(`\$(\d+)`)
+)
+
+func (q *Query) where() string {
+	return fmt.Sprintf("%s:%d: %s", q.File, q.Line, q.Name)
+}
+
+// loadQueries 读取 paths 中的 .sql 文件，目录中的 .sql 文件按文件名顺序读取
+func loadQueries(paths []string) ([]*Query, error) {
+	var files = make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.sql"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	var queries = make([]*Query, 0)
+	var names = make(map[string]*Query)
+	for _, file := range files {
+		qs, err := parseQueryFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, q := range qs {
+			if prev, ok := names[q.Name]; ok {
+				return nil, fmt.Errorf("%s: duplicate query name, first defined at %s:%d", q.where(), prev.File, prev.Line)
+			}
+			names[q.Name] = q
+			queries = append(queries, q)
+		}
+	}
+	return queries, nil
+}
+
+// parseQueryFile 把一个文件按 name 注释切分成查询，第一个 name 注释之前的内容被忽略
+func parseQueryFile(file string) ([]*Query, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var queries = make([]*Query, 0)
+	var current *Query
+	var body []string
+	var finish = func() error {
+		if current == nil {
+			return nil
+		}
+		current.SQL = strings.TrimRight(strings.TrimSpace(strings.Join(body, "\n")), "; \t\n")
+		body = nil
+		return current.check()
+	}
+	var scanner = bufio.NewScanner(f)
+	var lineno = 0
+	for scanner.Scan() {
+		lineno++
+		var line = scanner.Text()
+		var trimmed = strings.TrimSpace(line)
+		if m := nameRe.FindStringSubmatch(trimmed); m != nil {
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			current = &Query{Name: m[1], Mode: m[2], Result: m[3], File: file, Line: lineno}
+			queries = append(queries, current)
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "--") {
+			if m := paramRe.FindStringSubmatch(trimmed); m != nil {
+				current.Params = append(current.Params, &Param{Name: m[1], GoType: m[2]})
+			} else if len(body) == 0 {
+				current.Doc = append(current.Doc, strings.TrimSpace(strings.TrimPrefix(trimmed, "--")))
+			}
+			continue
+		}
+		if trimmed != "" || len(body) > 0 {
+			body = append(body, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	return queries, nil
+}
+
+// check 检查注释和 SQL 是否一致
+func (q *Query) check() error {
+	if !unicode.IsUpper(rune(q.Name[0])) {
+		return fmt.Errorf("%s: query name must be exported", q.where())
+	}
+	switch q.Mode {
+	case modeOne, modeMany:
+		if q.Result == "" {
+			return fmt.Errorf("%s: :%s needs a result type", q.where(), q.Mode)
+		}
+	case modeExec, modeExecRows:
+		if q.Result != "" {
+			return fmt.Errorf("%s: :%s doesn't take a result type", q.where(), q.Mode)
+		}
+	default:
+		return fmt.Errorf("%s: unknown mode :%s, use :one, :many, :exec or :execrows", q.where(), q.Mode)
+	}
+	if q.SQL == "" {
+		return fmt.Errorf("%s: query is empty", q.where())
+	}
+	var count = 0
+	for _, m := range argRe.FindAllStringSubmatch(maskSQL(q.SQL), -1) {
+		if n, _ := strconv.Atoi(m[1]); n > count {
+			count = n
+		}
+	}
+	if count != len(q.Params) {
+		return fmt.Errorf("%s: query uses %d parameters but declares %d", q.where(), count, len(q.Params))
+	}
+	return nil
+}
+
+// maskSQL 把字符串常量和注释替换成等长的空格，这样查找关键字、参数和逗号的时候不会被它们干扰
+func maskSQL(query string) string {
+	var out = []byte(query)
+	var blank = func(from, to int) {
+		for i := from; i < to && i < len(out); i++ {
+			if out[i] != '\n' {
+				out[i] = ' '
+			}
+		}
+	}
+	for i := 0; i < len(query); i++ {
+		switch {
+		case query[i] == '\'':
+			var j = i + 1
+			for j < len(query) {
+				if query[j] == '\'' {
+					if j+1 < len(query) && query[j+1] == '\'' {
+						j += 2
+						continue
+					}
+					break
+				}
+				j++
+			}
+			blank(i, j+1)
+			i = j
+		case strings.HasPrefix(query[i:], "--"):
+			var j = strings.IndexByte(query[i:], '\n')
+			if j < 0 {
+				j = len(query) - i
+			}
+			blank(i, i+j)
+			i += j
+		case strings.HasPrefix(query[i:], "/*"):
+			var j = strings.Index(query[i+2:], "*/")
+			if j < 0 {
+				j = len(query) - i - 2
+			}
+			blank(i, i+j+4)
+			i += j + 3
+		case query[i] == '$' && i+1 < len(query) && (query[i+1] == '$' || unicode.IsLetter(rune(query[i+1]))):
+			// PostgreSQL 的 $tag$...$tag$ 字符串
+			var end = strings.IndexByte(query[i+1:], '$')
+			if end < 0 {
+				continue
+			}
+			var tag = query[i : i+end+2]
+			var closing = strings.Index(query[i+len(tag):], tag)
+			if closing < 0 {
+				closing = len(query) - i - len(tag)
+			}
+			blank(i, i+len(tag)+closing+len(tag))
+			i += len(tag) + closing + len(tag) - 1
+		}
+	}
+	return string(out)
+}
+
+// sqlWord 是 SQL 中处于最外层的一个单词
+type sqlWord struct {
+	word       string
+	start, end int
+}
+
+// topWords 找出不在括号和引号里的单词，单词统一转成小写
+func topWords(masked string) []sqlWord {
+	var words = make([]sqlWord, 0)
+	var depth = 0
+	var quoted = false
+	for i := 0; i < len(masked); i++ {
+		var c = masked[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case depth == 0 && isWordChar(c) && (i == 0 || !isWordChar(masked[i-1]) && masked[i-1] != '$'):
+			var j = i
+			for j < len(masked) && isWordChar(masked[j]) {
+				j++
+			}
+			words = append(words, sqlWord{strings.ToLower(masked[i:j]), i, j})
+			i = j - 1
+		}
+	}
+	return words
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// selectEnds 是结束 SELECT 字段列表的关键字
+var selectEnds = map[string]bool{
+	"from": true, "into": true, "where": true, "group": true, "having": true, "window": true,
+	"order": true, "limit": true, "offset": true, "union": true, "intersect": true, "except": true,
+	"for": true,
+}
+
+// resultColumns 给出查询结果的字段名。有 RETURNING 的话取 RETURNING 的列表，否则取最外层
+// SELECT 的列表。 star 表示列表中有 * ，这时无法确定全部字段名。不加 AS 又不是简单字段名的
+// 表达式会返回 error
+func resultColumns(query string) (columns []string, star bool, err error) {
+	var masked = maskSQL(query)
+	var words = topWords(masked)
+	var start, end = -1, len(masked)
+	for idx, w := range words {
+		if w.word == "returning" {
+			start = w.end
+		}
+		if w.word == "select" && start < 0 {
+			start = w.end
+			for _, next := range words[idx+1:] {
+				if selectEnds[next.word] {
+					end = next.start
+					break
+				}
+			}
+		}
+	}
+	if start < 0 {
+		return nil, false, fmt.Errorf("query has no SELECT or RETURNING list")
+	}
+	if start > end {
+		end = len(masked)
+	}
+	var list = strings.TrimSpace(masked[start:end])
+	// 去掉 DISTINCT 、 DISTINCT ON (...) 和 ALL
+	var lower = strings.ToLower(list)
+	switch {
+	case strings.HasPrefix(lower, "distinct"):
+		list = strings.TrimSpace(list[len("distinct"):])
+		if strings.HasPrefix(strings.ToLower(list), "on") {
+			if closing := matchParen(list); closing > 0 {
+				list = strings.TrimSpace(list[closing+1:])
+			}
+		}
+	case strings.HasPrefix(lower, "all "):
+		list = strings.TrimSpace(list[len("all"):])
+	}
+	for _, item := range splitTop(list) {
+		var name, err = columnName(item)
+		if err != nil {
+			return nil, false, err
+		}
+		if name == "*" {
+			star = true
+			continue
+		}
+		columns = append(columns, name)
+	}
+	return columns, star, nil
+}
+
+// matchParen 返回第一个左括号对应的右括号的位置
+func matchParen(s string) int {
+	var depth = 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// splitTop 按不在括号和引号里的逗号切分
+func splitTop(list string) []string {
+	var items = make([]string, 0)
+	var depth, last = 0, 0
+	var quoted = false
+	for i := 0; i < len(list); i++ {
+		switch c := list[i]; {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(list[last:i]))
+			last = i + 1
+		}
+	}
+	return append(items, strings.TrimSpace(list[last:]))
+}
+
+var (
+	identRe = `(?:"[^"]+"|[\pL_][\pL\pN_$]*)`
+	// a 、 t.a 、 * 、 t.*
+	plainRe = regexp.MustCompile(`^(?:` + identRe + `\.)*(` + identRe + `|\*)$`)
+	// expr AS a ，或者 f(x) a 、 t.a b 这种省略了 AS 的写法
+	aliasRe    = regexp.MustCompile(`(?is)^.+\s+as\s+(` + identRe + `)$`)
+	implicitRe = regexp.MustCompile(`(?s)^(?:.*\)|(?:` + identRe + `\.)*` + identRe + `)\s+(` + identRe + `)$`)
+)
+
+// columnName 给出 SELECT 列表中一项对应的字段名，没有引号的名字按 PostgreSQL 的规则转成小写
+func columnName(item string) (string, error) {
+	var name string
+	if m := plainRe.FindStringSubmatch(item); m != nil {
+		name = m[1]
+	} else if m := aliasRe.FindStringSubmatch(item); m != nil {
+		name = m[1]
+	} else if m := implicitRe.FindStringSubmatch(item); m != nil && !strings.EqualFold(m[1], "end") {
+		name = m[1]
+	} else {
+		return "", fmt.Errorf("can't tell the column name of %q, give it an alias with AS", item)
+	}
+	if strings.HasPrefix(name, `"`) {
+		return strings.Trim(name, `"`), nil
+	}
+	return strings.ToLower(name), nil
+}
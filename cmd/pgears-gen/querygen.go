@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"path/filepath"
+	"strings"
+)
+
+// typePkgs 是参数和结果类型中可以直接使用的包
+var typePkgs = map[string]string{
+	"time": "time",
+	"sql":  "database/sql",
+	"json": "encoding/json",
+	"pq":   "github.com/lib/pq",
+}
+
+// generateQueries 生成 queries 对应的函数。结果是结构的查询会检查 SELECT 或 RETURNING 中的
+// 每个字段都在 structs 中对应的结构里有映射，对不上的话返回 error 而不是生成代码
+func generateQueries(pkg string, queries []*Query, structs map[string]*mapped) ([]byte, error) {
+	var imports = map[string]bool{"github.com/Dwarfartisan/pgears": true}
+	for _, q := range queries {
+		for _, p := range q.Params {
+			if err := typeImports(p.GoType, imports); err != nil {
+				return nil, fmt.Errorf("%s: param %s: %v", q.where(), p.Name, err)
+			}
+		}
+		if q.Mode == modeOne {
+			imports["database/sql"] = true
+		}
+		if q.Result == "" {
+			continue
+		}
+		if err := typeImports(q.Result, imports); err != nil {
+			return nil, fmt.Errorf("%s: result: %v", q.where(), err)
+		}
+		if err := checkResult(q, structs); err != nil {
+			return nil, err
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "// Code generated by pgears-gen queries. DO NOT EDIT.\n\npackage %s\n\n", pkg)
+	writeImports(&buf, imports)
+	for _, q := range queries {
+		writeQuery(&buf, q, structs[q.Result])
+	}
+	return formatSource(&buf)
+}
+
+// typeImports 检查类型表达式，把它用到的包加入 imports
+func typeImports(typ string, imports map[string]bool) error {
+	var expr, err = parser.ParseExpr(typ)
+	if err != nil {
+		return fmt.Errorf("invalid type %s", typ)
+	}
+	ast.Inspect(expr, func(node ast.Node) bool {
+		if sel, ok := node.(*ast.SelectorExpr); ok {
+			if ident, ok := sel.X.(*ast.Ident); ok {
+				if path, known := typePkgs[ident.Name]; known {
+					imports[path] = true
+				} else if err == nil {
+					err = fmt.Errorf("unknown package %s in type %s", ident.Name, typ)
+				}
+			}
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// checkResult 检查结果类型。没有包名、首字母大写的类型必须是 structs 中的结构，
+// 这时查询结果的每个字段都必须有映射
+func checkResult(q *Query, structs map[string]*mapped) error {
+	var st, ok = structs[q.Result]
+	if !ok {
+		if strings.ContainsAny(q.Result, ".*[]") || !ast.IsExported(q.Result) {
+			return nil
+		}
+		return fmt.Errorf("%s: struct %s not found", q.where(), q.Result)
+	}
+	if st.Open {
+		return nil
+	}
+	var columns, _, err = resultColumns(q.SQL)
+	if err != nil {
+		return fmt.Errorf("%s: %v", q.where(), err)
+	}
+	for _, col := range columns {
+		if !st.Columns[col] {
+			return fmt.Errorf("%s: column %s is not mapped by %s", q.where(), col, st.Name)
+		}
+	}
+	return nil
+}
+
+// writeQuery 生成一个查询的 SQL 常量和函数， st 为 nil 表示结果是单列的类型
+func writeQuery(buf *bytes.Buffer, q *Query, st *mapped) {
+	var lower = strings.ToLower(q.Name[:1]) + q.Name[1:]
+	var constName = lower + "SQL"
+	if strings.Contains(q.SQL, "`") {
+		fmt.Fprintf(buf, "\nconst %s = %q\n\n", constName, q.SQL)
+	} else {
+		fmt.Fprintf(buf, "\nconst %s = `%s`\n\n", constName, q.SQL)
+	}
+
+	if len(q.Doc) > 0 {
+		for _, line := range q.Doc {
+			fmt.Fprintf(buf, "// %s\n", line)
+		}
+	} else {
+		fmt.Fprintf(buf, "// %s 执行 %s 中的同名查询\n", q.Name, filepath.Base(q.File))
+	}
+	var params = []string{"db pgears.Session"}
+	var args = []string{constName}
+	for _, p := range q.Params {
+		var name = paramName(p.Name)
+		params = append(params, name+" "+p.GoType)
+		args = append(args, name)
+	}
+	var results string
+	switch {
+	case q.Mode == modeExec:
+		results = "error"
+	case q.Mode == modeExecRows:
+		results = "(int64, error)"
+	case st != nil && q.Mode == modeOne:
+		results = fmt.Sprintf("(*%s, error)", q.Result)
+	case st != nil:
+		results = fmt.Sprintf("([]*%s, error)", q.Result)
+	case q.Mode == modeOne:
+		results = fmt.Sprintf("(%s, error)", q.Result)
+	default:
+		results = fmt.Sprintf("([]%s, error)", q.Result)
+	}
+	fmt.Fprintf(buf, "func %s(%s) %s {\n", q.Name, strings.Join(params, ", "), results)
+
+	switch q.Mode {
+	case modeExec:
+		fmt.Fprintf(buf, "\t_, err := db.ExecSQL(%s)\n\treturn err\n}\n", strings.Join(args, ", "))
+		return
+	case modeExecRows:
+		fmt.Fprintf(buf, "\tres, err := db.ExecSQL(%s)\n", strings.Join(args, ", "))
+		buf.WriteString("\tif err != nil {\n\t\treturn 0, err\n\t}\n\treturn res.RowsAffected()\n}\n")
+		return
+	}
+
+	var obj = "nil"
+	var zero = "nil"
+	if st != nil {
+		obj = fmt.Sprintf("(*%s)(nil)", q.Result)
+	} else if q.Mode == modeOne {
+		zero = "ret"
+		fmt.Fprintf(buf, "\tvar ret %s\n", q.Result)
+	}
+	fmt.Fprintf(buf, "\trs, err := db.QuerySQL(%s, %s)\n", obj, strings.Join(args, ", "))
+	fmt.Fprintf(buf, "\tif err != nil {\n\t\treturn %s, err\n\t}\n\tdefer rs.Close()\n", zero)
+
+	if q.Mode == modeOne {
+		fmt.Fprintf(buf, "\tif !rs.Next() {\n\t\tif err := rs.Err(); err != nil {\n\t\t\treturn %s, err\n\t\t}\n", zero)
+		fmt.Fprintf(buf, "\t\treturn %s, sql.ErrNoRows\n\t}\n", zero)
+		if st != nil {
+			fmt.Fprintf(buf, "\tvar obj %s\n\tif err := rs.Load(&obj); err != nil {\n\t\treturn nil, err\n\t}\n", q.Result)
+			buf.WriteString("\treturn &obj, nil\n}\n")
+		} else {
+			buf.WriteString("\terr = rs.Scan(&ret)\n\treturn ret, err\n}\n")
+		}
+		return
+	}
+
+	if st != nil {
+		fmt.Fprintf(buf, "\tvar ret = make([]*%s, 0)\n\tfor rs.Next() {\n", q.Result)
+		fmt.Fprintf(buf, "\t\tvar obj %s\n\t\tif err := rs.Load(&obj); err != nil {\n\t\t\treturn nil, err\n\t\t}\n", q.Result)
+		buf.WriteString("\t\tret = append(ret, &obj)\n\t}\n")
+	} else {
+		fmt.Fprintf(buf, "\tvar ret = make([]%s, 0)\n\tfor rs.Next() {\n", q.Result)
+		fmt.Fprintf(buf, "\t\tvar item %s\n\t\tif err := rs.Scan(&item); err != nil {\n\t\t\treturn nil, err\n\t\t}\n", q.Result)
+		buf.WriteString("\t\tret = append(ret, item)\n\t}\n")
+	}
+	buf.WriteString("\treturn ret, rs.Err()\n}\n")
+}
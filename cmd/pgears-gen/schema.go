@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+// Table 是从数据库中读出来的一张表
+type Table struct {
+	Name    string
+	Columns []*Column
+}
+
+// Column 是表中的一个字段， DbGen 表示它的值由数据库生成（serial 、 identity 或者 SQLite 的 rowid）
+type Column struct {
+	Name    string
+	DbType  string
+	NotNull bool
+	PK      bool
+	DbGen   bool
+}
+
+// PKs 按字段顺序给出主键
+func (t *Table) PKs() []*Column {
+	var ret = make([]*Column, 0)
+	for _, col := range t.Columns {
+		if col.PK {
+			ret = append(ret, col)
+		}
+	}
+	return ret
+}
+
+// loadTables 读出当前 schema 中的表， only 不为空的时候只读这些表
+func loadTables(db *sql.DB, only []string) ([]*Table, error) {
+	var names []string
+	var err error
+	if len(only) > 0 {
+		names = only
+	} else if names, err = tableNames(db); err != nil {
+		return nil, err
+	}
+	var tables = make([]*Table, 0, len(names))
+	for _, name := range names {
+		var table = &Table{Name: name}
+		if table.Columns, err = loadColumns(db, name); err != nil {
+			return nil, err
+		}
+		if len(table.Columns) == 0 {
+			continue
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
+
+func tableNames(db *sql.DB) ([]string, error) {
+	var query = `SELECT table_name FROM information_schema.tables
+WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name`
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		query = `SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names = make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func loadColumns(db *sql.DB, table string) ([]*Column, error) {
+	var query = `SELECT c.column_name,
+	CASE WHEN c.data_type = 'ARRAY' THEN substr(c.udt_name, 2) || '[]'
+		WHEN c.data_type = 'USER-DEFINED' THEN c.udt_name ELSE c.data_type END,
+	c.is_nullable = 'NO',
+	EXISTS (SELECT 1 FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu ON kcu.constraint_name = tc.constraint_name
+			AND kcu.table_schema = tc.table_schema AND kcu.table_name = tc.table_name
+		WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = c.table_schema
+			AND tc.table_name = c.table_name AND kcu.column_name = c.column_name),
+	c.is_identity = 'YES' OR coalesce(c.column_default, '') LIKE 'nextval(%'
+FROM information_schema.columns c
+WHERE c.table_schema = current_schema() AND c.table_name = $1
+ORDER BY c.ordinal_position`
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		query = `SELECT name, type, "notnull" != 0, pk != 0, 0 FROM pragma_table_info($1) ORDER BY cid`
+	}
+	rows, err := db.Query(query, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns = make([]*Column, 0)
+	for rows.Next() {
+		var col Column
+		if err := rows.Scan(&col.Name, &col.DbType, &col.NotNull, &col.PK, &col.DbGen); err != nil {
+			return nil, err
+		}
+		columns = append(columns, &col)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// SQLite 里单独的 INTEGER PRIMARY KEY 就是 rowid ，不插入的话由数据库生成
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		var pks = (&Table{Columns: columns}).PKs()
+		if len(pks) == 1 && strings.EqualFold(pks[0].DbType, "integer") {
+			pks[0].DbGen = true
+		}
+	}
+	return columns, nil
+}
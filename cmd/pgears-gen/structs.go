@@ -0,0 +1,135 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/Dwarfartisan/pgears"
+)
+
+// mapped 是从 Go 源码中读出来的一个结构，Columns 是它映射的字段名。 Open 表示它嵌入了
+// 别的包里的结构，字段不全，这时不检查字段名
+type mapped struct {
+	Name    string
+	Columns map[string]bool
+	Open    bool
+}
+
+// loadStructs 读取 dir 中（不含测试文件和 skip）的结构定义，返回包名和结构。
+// 这里只做语法分析，不需要 dir 能通过编译，所以生成的文件可以跟结构放在同一个包里
+func loadStructs(dir, skip string) (string, map[string]*mapped, error) {
+	var files, err = filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return "", nil, err
+	}
+	var pkg string
+	var types = make(map[string]*ast.StructType)
+	var fset = token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") || (skip != "" && sameFile(file, skip)) {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return "", nil, err
+		}
+		pkg = f.Name.Name
+		for _, decl := range f.Decls {
+			var gen, ok = decl.(*ast.GenDecl)
+			if !ok || gen.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gen.Specs {
+				var ts = spec.(*ast.TypeSpec)
+				if st, ok := ts.Type.(*ast.StructType); ok {
+					types[ts.Name.Name] = st
+				}
+			}
+		}
+	}
+	var structs = make(map[string]*mapped, len(types))
+	for name, st := range types {
+		var m = &mapped{Name: name, Columns: make(map[string]bool)}
+		m.collect(st, types, "", map[string]bool{name: true})
+		structs[name] = m
+	}
+	return pkg, structs, nil
+}
+
+// collect 按 pgears 的规则收集结构中映射的字段名：没有 field 标签的字段按 Engine 默认的
+// SnakeCase 命名，跳过 field:"-" 和没有导出的字段，展开匿名嵌入和带 embedded 标签的结构。
+// prefix 是外层 embedded 标签给出的前缀， seen 用来避免循环嵌入
+func (m *mapped) collect(st *ast.StructType, types map[string]*ast.StructType, prefix string, seen map[string]bool) {
+	for _, fld := range st.Fields.List {
+		var tag reflect.StructTag
+		if fld.Tag != nil {
+			if value, err := strconv.Unquote(fld.Tag.Value); err == nil {
+				tag = reflect.StructTag(value)
+			}
+		}
+		var name = tag.Get("field")
+		if name == "-" {
+			continue
+		}
+		var embedded, tagged = tag.Lookup("embedded")
+		if (len(fld.Names) == 0 || tagged) && name == "" && tag.Get("jsonto") == "" {
+			var typ = fld.Type
+			if star, ok := typ.(*ast.StarExpr); ok {
+				typ = star.X
+			}
+			switch t := typ.(type) {
+			case *ast.Ident:
+				if inner, ok := types[t.Name]; ok && !seen[t.Name] {
+					seen[t.Name] = true
+					m.collect(inner, types, prefix+embedded, seen)
+					delete(seen, t.Name)
+					continue
+				}
+			case *ast.SelectorExpr:
+				// time.Time 这类是一个字段，其它包里的结构看不到它的字段
+				if !(t.Sel.Name == "Time" || strings.HasPrefix(t.Sel.Name, "Null")) {
+					m.Open = true
+					continue
+				}
+			}
+		}
+		var names = fld.Names
+		if len(names) == 0 {
+			names = []*ast.Ident{embeddedName(fld.Type)}
+		}
+		for _, ident := range names {
+			if ident == nil || !ident.IsExported() {
+				continue
+			}
+			if name != "" {
+				m.Columns[prefix+name] = true
+			} else {
+				m.Columns[prefix+pgears.SnakeCase.ColumnName(ident.Name)] = true
+			}
+		}
+	}
+}
+
+// embeddedName 给出匿名字段的字段名，也就是类型名
+func embeddedName(typ ast.Expr) *ast.Ident {
+	switch t := typ.(type) {
+	case *ast.StarExpr:
+		return embeddedName(t.X)
+	case *ast.Ident:
+		return t
+	case *ast.SelectorExpr:
+		return t.Sel
+	}
+	return nil
+}
+
+func sameFile(a, b string) bool {
+	var absA, errA = filepath.Abs(a)
+	var absB, errB = filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
@@ -0,0 +1,22 @@
+-- name: GetAccount :one Account
+-- param: id int64
+SELECT id, name, email, created_at FROM account WHERE id = $1;
+
+-- name: ListAccounts :many Account
+-- ListAccounts 按名字列出账户
+-- param: prefix string
+SELECT id, name, email, created_at FROM account WHERE name LIKE $1 || '%' ORDER BY name;
+
+-- name: CountAccounts :one int64
+SELECT count(*) FROM account;
+
+-- name: AccountNames :many string
+SELECT name FROM account ORDER BY name;
+
+-- name: RenameAccount :execrows
+-- param: id int64
+-- param: name string
+UPDATE account SET name = $2 WHERE id = $1;
+
+-- name: DeleteAccounts :exec
+DELETE FROM account;
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+type Account struct {
+	ID        int64 `pk:"true" dbgen:"true"`
+	Name      string
+	Email     *string
+	CreatedAt time.Time
+}
@@ -0,0 +1,29 @@
+-- generate 的测试用的 SQLite 表，生成结果见 models.golden
+CREATE TABLE account (
+	id INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	email VARCHAR(100),
+	balance REAL NOT NULL DEFAULT 0,
+	created_at TIMESTAMP NOT NULL,
+	avatar BLOB
+);
+
+CREATE TABLE account_tag (
+	account_id INTEGER NOT NULL,
+	tag TEXT NOT NULL,
+	weight INT,
+	PRIMARY KEY (account_id, tag)
+);
+
+CREATE TABLE event (
+	id INTEGER PRIMARY KEY,
+	type TEXT NOT NULL,
+	payload JSON NOT NULL,
+	meta JSON,
+	active BOOLEAN NOT NULL
+);
+
+CREATE TABLE audit_log (
+	message TEXT,
+	logged_at DATETIME
+);
@@ -7,6 +7,8 @@ package dbdriver
 
 import(
 	"database/sql"
+	"fmt"
+	"reflect"
 	
 	"github.com/lib/pq"
 
@@ -24,3 +26,85 @@ func PostpresConnection(url string) (*sql.DB, error){
 	}
 	return conn,nil
 }
+
+// PgFieldType 通过反射把结构字段的 Go 类型翻译成 PostgreSQL 的字段类型。
+// serial 为 true 表示这是由数据库生成的整数主键，按位数给出 serial 或 bigserial ；
+// json 为 true 表示这是 jsonto 字段，总是存成 jsonb
+func PgFieldType(typ reflect.Type, serial bool, json bool) string {
+	if json {
+		return "jsonb"
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	// NullString 、 NullTime 一类的类型按它们的值字段推断
+	if PgNullable(typ) {
+		for i := 0; i < typ.NumField(); i++ {
+			if name := typ.Field(i).Name; name != "Valid" {
+				return PgFieldType(typ.Field(i).Type, serial, false)
+			}
+		}
+	}
+	switch typ.Name() {
+	case "Time":
+		if typ.PkgPath() == "time" {
+			return "timestamptz"
+		}
+	case "UUID":
+		return "uuid"
+	case "Decimal", "Rat", "Numeric":
+		return "numeric"
+	}
+	switch typ.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int8, reflect.Int16, reflect.Uint8:
+		if serial {
+			return "smallserial"
+		}
+		return "smallint"
+	case reflect.Int32, reflect.Uint16:
+		if serial {
+			return "serial"
+		}
+		return "integer"
+	case reflect.Int, reflect.Int64, reflect.Uint32:
+		if serial {
+			return "bigserial"
+		}
+		return "bigint"
+	case reflect.Uint, reflect.Uint64:
+		// bigint 放不下最高位为 1 的 uint64 ，serial 生成的值都是正的，不受影响
+		if serial {
+			return "bigserial"
+		}
+		return "numeric(20)"
+	case reflect.Float32:
+		return "real"
+	case reflect.Float64:
+		return "double precision"
+	case reflect.String:
+		return "text"
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			if typ.Kind() == reflect.Array && typ.Len() == 16 {
+				return "uuid"
+			}
+			return "bytea"
+		}
+		return PgFieldType(typ.Elem(), false, false) + "[]"
+	case reflect.Map, reflect.Struct, reflect.Interface:
+		return "jsonb"
+	}
+	panic(fmt.Errorf("can't infer postgres type for %v, please set the fieldtype tag", typ))
+}
+
+// PgNullable 判断一个非指针类型是否是 sql.NullString 、 pq.NullTime 这类自带 Valid 标记，
+// 可以存 NULL 的结构
+func PgNullable(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct || typ.NumField() != 2 {
+		return false
+	}
+	var valid, ok = typ.FieldByName("Valid")
+	return ok && valid.Type.Kind() == reflect.Bool
+}
@@ -7,6 +7,7 @@ import(
 	"reflect"
 	_ "github.com/mattn/go-sqlite3"
 	"errors"
+	"strings"
 )
 
 
@@ -63,3 +64,25 @@ func getSqlite3DbFieldTypeName(Gotype *reflect.Type,GoName string) string{
 
 
 
+
+// SqliteCastType 把 CAST 里的 PostgreSQL 类型名换成 SQLite 能理解的存储类型
+func SqliteCastType(pgtype string) string {
+	var typ = strings.ToLower(strings.TrimSpace(pgtype))
+	if idx := strings.Index(typ, "("); idx >= 0 {
+		typ = strings.TrimSpace(typ[:idx])
+	}
+	switch typ {
+	case "smallint", "integer", "int", "int2", "int4", "int8", "bigint", "serial", "bigserial", "boolean", "bool":
+		return "integer"
+	case "real", "float4", "float8", "double precision", "float":
+		return "real"
+	case "numeric", "decimal":
+		return "numeric"
+	case "bytea":
+		return "blob"
+	case "text", "varchar", "character varying", "char", "character", "uuid", "json", "jsonb",
+		"date", "time", "timestamp", "timestamptz", "timestamp with time zone", "timestamp without time zone":
+		return "text"
+	}
+	return pgtype
+}
@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"reflect"
-	_"time"
+	"time"
 	"sort"
+	"strconv"
+	"strings"
 	"errors"
 	"github.com/Dwarfartisan/pgears/dbdriver"
 	"github.com/Dwarfartisan/pgears/exp"
@@ -23,7 +25,44 @@ type DbField struct {
 	IsPK    bool
 	DbGen   bool
 	NotNull bool
+	// IsVersion 表示这是乐观锁的版本号字段，由 version:"true" 指定
+	IsVersion bool
+	// IsSoftDelete 表示这是软删除的时间戳字段，由 softdelete:"true" 指定
+	IsSoftDelete bool
+	// AutoCreate 和 AutoUpdate 表示这是在插入、更新时自动填写当前时间的字段，
+	// 由 autocreate:"true" 和 autoupdate:"true" 指定，同时是 dbgen 的话由数据库取时间
+	AutoCreate bool
+	AutoUpdate bool
+	// IsJson 表示字段由 jsonto 标签指定以 json 格式存储
+	IsJson bool
+	// Sensitive 表示字段的值不应该出现在日志里，由 sensitive:"true" 指定，见 Call.SafeArgs
+	Sensitive bool
+	// 以下是建表时使用的约束，见 GetCreateTableSQL 和 GetCreateIndexSQL
+	// Default 是字段的默认值表达式，由 default:"now()" 指定，原样写进 DDL
+	Default string
+	// Unique 由 unique:"true" 指定
+	Unique bool
+	// Indexes 和 UniqueIndexes 是字段所属的索引名，由 index:"name" 和 unique_index:"name"
+	// 指定，多个索引用逗号分隔，写 "true" 的话自动命名。同名的字段按结构中的顺序组成复合索引
+	Indexes       []string
+	UniqueIndexes []string
+	// Check 是字段的 CHECK 约束，由 check:"price > 0" 指定
+	Check string
+	// References 是外键指向的字段，由 references:"pkg.Type.Field" 指定， OnDelete 是
+	// ondelete:"cascade" 指定的删除动作。 RefTable 和 RefColumn 是目标类型映射以后解析出来的表名和字段名
+	References string
+	OnDelete   string
+	RefTable   string
+	RefColumn  string
+	// Size 由 size:"255" 指定，PostgreSQL 下字符串推断为 varchar(255)
+	Size int
+	// Precision 和 Scale 由 precision:"10,2" 指定，PostgreSQL 下推断为 numeric(10,2)
+	Precision int
+	Scale     int
+	// Index 是字段在结构中的索引路径，嵌入结构中的字段不止一层，见 flatten
+	Index []int
 	Extract func(reflect.Value) (interface{}, func() error)
+	field reflect.StructField
 }
 
 // NewDbField 是 DbField 的内部构造函数，通常由其它pgears内部类型调用
@@ -43,7 +82,11 @@ func NewDbField(fieldStruct *reflect.StructField) *DbField {
 
 	var tag = fieldStruct.Tag
 	
+	// 没有 field 标签的字段按 SnakeCase 命名， Engine 注册类型时会换成它自己的命名规则
 	ret.DbName = tag.Get("field")
+	if ret.DbName == "" {
+		ret.DbName = SnakeCase.ColumnName(ret.GoName)
+	}
 	ret.DbFieldType = tag.Get("fieldtype")
 
 	if pk := tag.Get("pk"); pk == "true" {
@@ -52,7 +95,31 @@ func NewDbField(fieldStruct *reflect.StructField) *DbField {
 	if dbgen := tag.Get("dbgen"); dbgen == "true" {
 		ret.DbGen = true
 	}
-	if tag.Get("jsonto") == "" {
+	if version := tag.Get("version"); version == "true" {
+		ret.IsVersion = true
+	}
+	if softdelete := tag.Get("softdelete"); softdelete == "true" {
+		// 恢复的时候要把它写回 null ，值类型的 time.Time 做不到
+		if ret.NotNull && !isNullTime(ftype) {
+			panic(fmt.Sprintf("softdelete field %s must be a pointer or a null time type", ret.GoName))
+		}
+		ret.IsSoftDelete = true
+	}
+	if tag.Get("autocreate") == "true" {
+		ret.AutoCreate = true
+	}
+	if tag.Get("autoupdate") == "true" {
+		ret.AutoUpdate = true
+	}
+	if tag.Get("sensitive") == "true" {
+		ret.Sensitive = true
+	}
+	if (ret.AutoCreate || ret.AutoUpdate) && !isTimeType(ftype) {
+		panic(fmt.Sprintf("auto timestamp field %s must be a time type", ret.GoName))
+	}
+	ret.parseSchema(tag)
+	ret.IsJson = tag.Get("jsonto") != ""
+	if !ret.IsJson {
 		ret.Extract = func(field reflect.Value) (interface{}, func() error) {
 			return field.Addr().Interface(), nil
 		}
@@ -72,6 +139,64 @@ func NewDbField(fieldStruct *reflect.StructField) *DbField {
 	return &ret
 }
 
+// parseSchema 解析建表用的那些标签，格式不对的话 panic
+func (field *DbField) parseSchema(tag reflect.StructTag) {
+	field.Default = tag.Get("default")
+	field.Unique = tag.Get("unique") == "true"
+	field.Indexes = splitNames(tag.Get("index"))
+	field.UniqueIndexes = splitNames(tag.Get("unique_index"))
+	field.Check = tag.Get("check")
+	field.References = tag.Get("references")
+	if ondelete := tag.Get("ondelete"); ondelete != "" {
+		var action = strings.ToUpper(strings.Join(strings.Fields(ondelete), " "))
+		switch action {
+		case "CASCADE", "RESTRICT", "SET NULL", "SET DEFAULT", "NO ACTION":
+			field.OnDelete = action
+		default:
+			panic(fmt.Sprintf("field %s has an unknown ondelete action %q", field.GoName, ondelete))
+		}
+		if field.References == "" {
+			panic(fmt.Sprintf("field %s has ondelete but no references", field.GoName))
+		}
+	}
+	if size := tag.Get("size"); size != "" {
+		var n, err = strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			panic(fmt.Sprintf("field %s has an invalid size %q", field.GoName, size))
+		}
+		field.Size = n
+	}
+	if precision := tag.Get("precision"); precision != "" {
+		var parts = strings.SplitN(precision, ",", 2)
+		var p, err = strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil || p <= 0 {
+			panic(fmt.Sprintf("field %s has an invalid precision %q", field.GoName, precision))
+		}
+		field.Precision = p
+		if len(parts) == 2 {
+			var s, err = strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil || s < 0 || s > p {
+				panic(fmt.Sprintf("field %s has an invalid precision %q", field.GoName, precision))
+			}
+			field.Scale = s
+		}
+	}
+}
+
+// splitNames 拆分逗号分隔的名字列表，空串返回 nil
+func splitNames(names string) []string {
+	if names == "" {
+		return nil
+	}
+	var ret = make([]string, 0)
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
 // FieldMap 结构用于管理字段组的双键 map，这样就可以根据结构或表字段名找到对应的字段
 type FieldMap struct {
 	gomap map[string]*DbField
@@ -132,7 +257,8 @@ func (fm *FieldMap) DbKeys() []string {
 	return ret
 }
 
-type structFetchFunc func(row *sql.Rows, obj interface{})
+// structFetchFunc 把 row 的当前行加载到 obj ，返回 Scan 和 JSON 解码的错误
+type structFetchFunc func(row *sql.Rows, obj interface{}) error
 
 // DbTable 用 Go 语言描述了数据表的结构
 type DbTable struct {
@@ -143,22 +269,37 @@ type DbTable struct {
 	NPk       *FieldMap
 	DbGen     *FieldMap
 	NDbGen    *FieldMap
+	// Version 是乐观锁的版本号字段，没有的话为 nil
+	Version *DbField
+	// SoftDelete 是软删除的时间戳字段，没有的话为 nil
+	SoftDelete *DbField
 	// all 从数据库中加载所有字段的数据，pk 仅加载主键列表， npk 仅加载非pk字段，用于 Select From Where 类的对象加载
 	// dbgens 仅加载dbgen字段，用于 insert into returning 类的对象存储
 	pk        structFetchFunc
 	npk       structFetchFunc
 	returning structFetchFunc
 	all       structFetchFunc
+	// ordered 是按结构中的顺序排列的全部字段
+	ordered []*DbField
 }
 
-// NewDbTable 构造一个数据表映射结构
+// NewDbTable 构造一个数据表映射结构，没有 field 标签的字段按 SnakeCase 命名
 func NewDbTable(typ *reflect.Type, tablename string) *DbTable {
+	return newDbTable(typ, tablename, SnakeCase)
+}
+
+// newDbTable 是 NewDbTable 的实现， naming 用于没有 field 标签的字段。嵌入的结构会展开，
+// 见 flatten 。两个字段映射到同一个字段名的话 panic
+func newDbTable(typ *reflect.Type, tablename string, naming NamingStrategy) *DbTable {
 	var table = DbTable{tablename, typ, NewFieldMap(),
 		NewFieldMap(), NewFieldMap(), NewFieldMap(), NewFieldMap(),
-		nil, nil, nil, nil}
-	for i := 0; i < (*typ).NumField(); i++ {
-		var field = (*typ).Field(i)
-		var df = NewDbField(&field)
+		nil, nil, nil, nil, nil, nil, nil}
+	table.ordered = flatten(*typ, nil, "", "", naming)
+	for _, df := range table.ordered {
+		if other, ok := table.Fields.DbGet(df.DbName); ok {
+			panic(fmt.Sprintf("field %s and %s of %s are both mapped to %s",
+				other.GoName, df.GoName, fullGoName(*typ), df.DbName))
+		}
 		table.Fields.Set(df)
 		if df.IsPK {
 			table.Pk.Set(df)
@@ -170,24 +311,195 @@ func NewDbTable(typ *reflect.Type, tablename string) *DbTable {
 		} else {
 			table.NDbGen.Set(df)
 		}
+		if df.IsVersion {
+			table.Version = df
+		}
+		if df.IsSoftDelete {
+			table.SoftDelete = df
+		}
+		// 自动命名的索引
+		for idx, name := range df.Indexes {
+			if name == "true" {
+				df.Indexes[idx] = fmt.Sprintf("%s_%s_idx", tablename, df.DbName)
+			}
+		}
+		for idx, name := range df.UniqueIndexes {
+			if name == "true" {
+				df.UniqueIndexes[idx] = fmt.Sprintf("%s_%s_key", tablename, df.DbName)
+			}
+		}
 	}
 	table.makeLoads()
 	return &table
 }
 
+// flatten 按结构中的顺序列出 typ 映射的字段， index 、 goPrefix 和 dbPrefix 是外层嵌入结构的
+// 路径和前缀。规则是：
+// - field:"-" 的字段和没有导出的字段不映射
+// - 匿名嵌入的结构（包括指针）展开成它的字段， GoName 跟 Go 的字段提升一样不带前缀，
+// 外层同名的字段优先，同一层的同名字段有歧义，跟 Go 一样都不映射
+// - 带 embedded 标签的具名结构字段也展开， GoName 是 Addr.Street 这样的路径，
+// embedded:"addr_" 的值作为其中每个字段名的前缀，匿名嵌入的结构也可以用它加前缀
+// - 写了 field 或 jsonto 标签的结构，以及 time.Time 、实现了 sql.Scanner 的类型仍然是一个字段
+func flatten(typ reflect.Type, index []int, goPrefix, dbPrefix string, naming NamingStrategy) []*DbField {
+	var fields, _ = flattenFields(typ, index, goPrefix, dbPrefix, naming)
+	return fields
+}
+
+// flattenFields 是 flatten 的实现，另外返回有歧义的字段名和出现歧义的深度（路径的长度），
+// 外层要靠它判断更深的同名字段是不是也被遮住了
+func flattenFields(typ reflect.Type, index []int, goPrefix, dbPrefix string,
+	naming NamingStrategy) ([]*DbField, map[string]int) {
+	var fields = make([]*DbField, 0, typ.NumField())
+	var byName = make(map[string]int)
+	var ambiguous = make(map[string]int)
+	var remove = func(pos int) {
+		delete(byName, fields[pos].GoName)
+		fields = append(fields[:pos], fields[pos+1:]...)
+		for name, p := range byName {
+			if p > pos {
+				byName[name] = p - 1
+			}
+		}
+	}
+	// hide 记下 name 在 depth 这一层有歧义，这一层和更深的同名字段都不映射
+	var hide = func(name string, depth int) {
+		if pos, ok := byName[name]; ok {
+			if len(fields[pos].Index) < depth {
+				return
+			}
+			remove(pos)
+		}
+		if old, ok := ambiguous[name]; !ok || depth < old {
+			ambiguous[name] = depth
+		}
+	}
+	var add = func(df *DbField) {
+		var depth = len(df.Index)
+		if old, ok := ambiguous[df.GoName]; ok && old <= depth {
+			return
+		}
+		if pos, ok := byName[df.GoName]; ok {
+			switch other := len(fields[pos].Index); {
+			case other < depth:
+				return
+			case other == depth:
+				hide(df.GoName, depth)
+				return
+			}
+			remove(pos)
+		}
+		byName[df.GoName] = len(fields)
+		fields = append(fields, df)
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		var field = typ.Field(i)
+		var name = field.Tag.Get("field")
+		if name == "-" {
+			continue
+		}
+		var path = append(append([]int{}, index...), i)
+		var prefix, tagged = field.Tag.Lookup("embedded")
+		if (field.Anonymous || tagged) && isFlattenable(field) {
+			// 没有导出的结构类型，用指针嵌入的话没办法分配
+			if field.PkgPath != "" && field.Type.Kind() == reflect.Ptr {
+				continue
+			}
+			var inner = field.Type
+			if inner.Kind() == reflect.Ptr {
+				inner = inner.Elem()
+			}
+			var innerPrefix = goPrefix
+			if !field.Anonymous {
+				innerPrefix = goPrefix + field.Name + "."
+			}
+			var inners, hidden = flattenFields(inner, path, innerPrefix, dbPrefix+prefix, naming)
+			for name, depth := range hidden {
+				hide(name, depth)
+			}
+			for _, df := range inners {
+				add(df)
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		var df = NewDbField(&field)
+		df.GoName = goPrefix + field.Name
+		if name == "" {
+			df.DbName = naming.ColumnName(field.Name)
+		}
+		df.DbName = dbPrefix + df.DbName
+		df.Index = path
+		df.field = field
+		add(df)
+	}
+	return fields, ambiguous
+}
+
+// isFlattenable 判断一个嵌入的字段是不是要展开的结构
+func isFlattenable(field reflect.StructField) bool {
+	var typ = field.Type
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || field.Tag.Get("field") != "" || field.Tag.Get("jsonto") != "" {
+		return false
+	}
+	var scanner = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	return typ != reflect.TypeOf(time.Time{}) && !reflect.PtrTo(typ).Implements(scanner)
+}
+
+// fieldOf 按 dbf.Index 找到 val 中对应的字段。路上遇到为 nil 的嵌入结构指针时， alloc 为 true
+// 就分配一个新的结构，否则返回字段类型的零值，这样只读的时候不会修改传入的对象
+func fieldOf(val reflect.Value, dbf *DbField, alloc bool) reflect.Value {
+	for depth, i := range dbf.Index {
+		if depth > 0 && val.Kind() == reflect.Ptr {
+			if val.IsNil() {
+				if !alloc {
+					return reflect.Zero(dbf.field.Type)
+				}
+				val.Set(reflect.New(val.Type().Elem()))
+			}
+			val = val.Elem()
+		}
+		val = val.Field(i)
+	}
+	return val
+}
+
+// arg 按 ExtractField 的规则取出 val 中 goname 字段作为 SQL 参数的值
+func (dbt *DbTable) arg(val reflect.Value, goname string) interface{} {
+	var dbf, _ = dbt.Fields.GoGet(goname)
+	return ExtractField(fieldOf(val, dbf, false), dbf.field)
+}
+
+// fieldValue 返回 val 中 goname 字段可以写入的值
+func (dbt *DbTable) fieldValue(val reflect.Value, goname string) reflect.Value {
+	var dbf, _ = dbt.Fields.GoGet(goname)
+	return fieldOf(val, dbf, true)
+}
+
 // 有时候调用者要根据具给定业务对象的内容拼接SQL表达式，为了便利，这时候需要提供一个既定的参考列表
 // 而非确定的表达式，以下若干Extract和XxxGears方法用于这种场合
 // DbTable 是已经解析过的结构体和数据表的定义对照表，所以从中可以生成表、主键和（非主键）数据字段
 // 的列表以及用于 where 的 筛选条件（即所有主键的 and 表达式）
 
-// Extract 方法从 DbTable 结构中得到分别表示主键、字段表达式和条件表达式的部分，便于拼接
+// Extract 方法从 DbTable 结构中得到分别表示主键、字段表达式和条件表达式的部分，便于拼接。
+// 带 softdelete 字段的表，条件中会加上 deleted_at is NULL ，排除已经软删除的行
 func (dbt *DbTable) Extract() (t *exp.Table, pk []exp.Exp, other []exp.Exp, cond exp.Exp) {
+	return dbt.extract(false)
+}
+
+// extract 是 Extract 的实现， unscoped 为 true 时不排除软删除的行
+func (dbt *DbTable) extract(unscoped bool) (t *exp.Table, pk []exp.Exp, other []exp.Exp, cond exp.Exp) {
 	t = exp.TableAs(fullGoName(*dbt.gotype), dbt.tablename)
 	pk = make([]exp.Exp, 0, dbt.Pk.Length())
 	other = make([]exp.Exp, 0, dbt.NPk.Length())
-	for _, key := range dbt.Fields.GoKeys() {
+	// 按结构中字段的顺序，建表语句的字段和 PRIMARY KEY 的顺序才跟结构定义一致
+	for _, dbf := range dbt.ordered {
 		// 这里要取不是pk的
-		dbf, _ := dbt.Fields.GoGet(key)
 		var f = exp.Field{Table: t, GoName: dbf.GoName, DbName: dbf.DbName}
 		if dbf.IsPK {
 			pk = append(pk, &f)
@@ -205,6 +517,9 @@ func (dbt *DbTable) Extract() (t *exp.Table, pk []exp.Exp, other []exp.Exp, cond
 				cond)
 		}
 	}
+	if !unscoped {
+		cond = dbt.scoped(t, cond)
+	}
 	return t, pk, other, cond
 }
 
@@ -290,9 +605,9 @@ func (dbt *DbTable) FetchExpr() (expr exp.Exp, names []string) {
 	t := exp.TableAs(fullGoName(*dbt.gotype), dbt.tablename)
 	pk := make([]exp.Exp, 0, dbt.Pk.Length())
 	other := make([]exp.Exp, 0, dbt.Fields.Length())
-	for _, key := range dbt.Fields.GoKeys() {
+	// 按结构中字段的顺序，建表语句的字段和 PRIMARY KEY 的顺序才跟结构定义一致
+	for _, dbf := range dbt.ordered {
 		// 这里要取不是pk的
-		dbf, _ := dbt.Fields.GoGet(key)
 		var f = exp.Field{Table: t, GoName: dbf.GoName, DbName: dbf.DbName}
 		if dbf.IsPK {
 			pk = append(pk, &f)
@@ -310,11 +625,11 @@ func (dbt *DbTable) FetchExpr() (expr exp.Exp, names []string) {
 				cond)
 		}
 	}
-	return exp.Select(other...).Where(cond), gokeys
+	return exp.Select(other...).Where(dbt.scoped(t, cond)), gokeys
 }
 
 // MergeInsertExpr 方法生成一个用于 Insert 的表达式，其中不包括在数据库端自动生成的字段，这些字段包含在
-// returning 中
+// returning 中。dbgen 的 autocreate/autoupdate 字段例外，它们插入 CURRENT_TIMESTAMP 并且同样 returning
 func (dbt *DbTable) MergeInsertExpr() (exp.Exp, []string) {
 	t := exp.TableAs(fullGoName(*dbt.gotype), dbt.tablename)
 	dbgen := make([]exp.Exp, 0, dbt.DbGen.Length())
@@ -328,6 +643,10 @@ func (dbt *DbTable) MergeInsertExpr() (exp.Exp, []string) {
 		var f = exp.Field{Table: t, GoName: dbf.GoName, DbName: dbf.DbName}
 		if dbf.DbGen {
 			dbgen = append(dbgen, &f)
+			if dbf.isAutoTime() {
+				other = append(other, &f)
+				args = append(args, exp.Now())
+			}
 		} else {
 			other = append(other, &f)
 			arg := exp.Arg(idx)
@@ -339,17 +658,23 @@ func (dbt *DbTable) MergeInsertExpr() (exp.Exp, []string) {
 	return exp.Insert(t, other...).Values(args...).Returning(dbgen...), names
 }
 
-// AllInsertExpr 方法生成一个用于 Insert 的表达式，包含所有的字段，包括dbgen
+// AllInsertExpr 方法生成一个用于 Insert 的表达式，包含所有的字段，包括dbgen，
+// 其中 dbgen 的 autocreate/autoupdate 字段插入 CURRENT_TIMESTAMP ，不占用参数
 func (dbt *DbTable) AllInsertExpr() (exp.Exp, []string) {
 	t := exp.TableAs(fullGoName(*dbt.gotype), dbt.tablename)
 	fields := make([]exp.Exp, 0, dbt.Fields.Length())
 	args := make([]exp.Exp, 0, dbt.Fields.Length())
-	names := dbt.Fields.GoKeys()
-	for idx, key := range names {
+	names := make([]string, 0, dbt.Fields.Length())
+	for _, key := range dbt.Fields.GoKeys() {
 		dbf, _ := dbt.Fields.GoGet(key)
 		var f = exp.Field{Table: t, GoName: dbf.GoName, DbName: dbf.DbName}
 		fields = append(fields, &f)
-		arg := exp.Arg(idx + 1)
+		if dbf.DbGen && dbf.isAutoTime() {
+			args = append(args, exp.Now())
+			continue
+		}
+		names = append(names, key)
+		arg := exp.Arg(len(names))
 		args = append(args, arg)
 	}
 	return exp.Insert(t, fields...).Values(args...), names
@@ -357,28 +682,61 @@ func (dbt *DbTable) AllInsertExpr() (exp.Exp, []string) {
 
 // UpdateExpr 方法生成一个用于 Update 的表达式，这里需要调用者给出准备Update的字段名，
 // 函数生成形如 Update XXX Set ... Where cond 的 SQL 表达式，
-// update 语句中包含主键字段列表，所以虽然它的sets由用户指定，仍然返回参数命名表
+// update 语句中包含主键字段列表，所以虽然它的sets由用户指定，仍然返回参数命名表。
+// 如果表有 version 字段，sets 中不用写它，这里会生成 version=version+1 ，并在条件中加上
+// version=$n ，对应的参数名（即更新前的版本号）排在参数命名表的最后。
+// 软删除的行不会被更新， autoupdate 字段不用写在 sets 里，总是会被更新
 func (dbt *DbTable) UpdateExpr(sets []string) (expr exp.Exp, names []string) {
-	t := exp.TableAs(fullGoName(*dbt.gotype), dbt.tablename)
-	copy(names, sets)
-	pk := make([]exp.Exp, 0, dbt.Pk.Length())
-	setExprs := make([]exp.Exp, 0, len(sets))
-	for idx, key := range sets {
-		arg := exp.Arg(idx + 1)
-		setExprs = append(setExprs, exp.Equal(t.Field(key), arg))
-	}
+	return dbt.updateExpr(sets, false)
+}
 
-	start := len(sets)
-	var gokeys = dbt.Pk.GoKeys()
-	for _, key := range gokeys {
+// updateExpr 是 UpdateExpr 的实现， unscoped 为 true 时软删除的行也会被更新
+func (dbt *DbTable) updateExpr(sets []string, unscoped bool) (expr exp.Exp, names []string) {
+	t := exp.TableAs(fullGoName(*dbt.gotype), dbt.tablename)
+	names = make([]string, 0, len(sets)+dbt.Pk.Length()+1)
+	setExprs := make([]exp.Exp, 0, len(sets)+1)
+	// autoupdate 字段总是要更新的，dbgen 的由数据库取时间，所以不占参数
+	sets = append([]string{}, sets...)
+	for _, key := range dbt.Fields.GoKeys() {
 		dbf, _ := dbt.Fields.GoGet(key)
-		var f = exp.Field{Table: t, GoName: dbf.GoName, DbName: dbf.DbName}
-		pk = append(pk, &f)
+		if dbf.AutoUpdate && !dbf.DbGen && !hasString(sets, key) {
+			sets = append(sets, key)
+		}
+	}
+	for _, key := range sets {
+		if dbt.Version != nil && key == dbt.Version.GoName {
+			continue
+		}
+		if dbf, ok := dbt.Fields.GoGet(key); ok && dbf.AutoUpdate && dbf.DbGen {
+			continue
+		}
 		names = append(names, key)
+		arg := exp.Arg(len(names))
+		if dbf, ok := dbt.Fields.GoGet(key); ok {
+			var f = exp.Field{Table: t, GoName: dbf.GoName, DbName: dbf.DbName}
+			setExprs = append(setExprs, exp.Equal(&f, arg))
+		} else {
+			setExprs = append(setExprs, exp.Equal(t.Field(key), arg))
+		}
+	}
+	for _, key := range dbt.Fields.GoKeys() {
+		dbf, _ := dbt.Fields.GoGet(key)
+		if dbf.AutoUpdate && dbf.DbGen {
+			var f = exp.Field{Table: t, GoName: dbf.GoName, DbName: dbf.DbName}
+			setExprs = append(setExprs, exp.Equal(&f, exp.Now()))
+		}
 	}
+	if dbt.Version != nil {
+		var v = exp.Field{Table: t, GoName: dbt.Version.GoName, DbName: dbt.Version.DbName}
+		setExprs = append(setExprs, exp.Equal(&v, exp.Add(&v, exp.Integer(1))))
+	}
+
+	start := len(names)
+	var gokeys = dbt.Pk.GoKeys()
+	names = append(names, gokeys...)
 
 	var f, _ = dbt.Pk.GoGet(gokeys[0])
-	cond := exp.Equal(t.Field(f.GoName), exp.Arg(start))
+	cond := exp.Equal(t.Field(f.GoName), exp.Arg(start+1))
 	if len(gokeys) > 1 {
 		for idx, key := range gokeys[1:] {
 			var _f, _ = dbt.Pk.GoGet(key)
@@ -386,48 +744,212 @@ func (dbt *DbTable) UpdateExpr(sets []string) (expr exp.Exp, names []string) {
 				cond)
 		}
 	}
+	if dbt.Version != nil {
+		names = append(names, dbt.Version.GoName)
+		cond = exp.And(cond, exp.Equal(t.Field(dbt.Version.GoName), exp.Arg(len(names))))
+	}
+	if !unscoped {
+		cond = dbt.scoped(t, cond)
+	}
 
 	return exp.Update(t).Set(setExprs...).Where(cond), names
 }
 
 
+// scoped 给带 softdelete 字段的表的条件加上 deleted_at is NULL
+func (dbt *DbTable) scoped(t *exp.Table, cond exp.Exp) exp.Exp {
+	if dbt.SoftDelete == nil {
+		return cond
+	}
+	return exp.And(cond, exp.FieldIsNull(t.Field(dbt.SoftDelete.GoName)))
+}
+
 //把当前表对象直接转换成建表语句
+// SQLite 的字段类型直接取 fieldtype 标签，PostgreSQL 下没有写 fieldtype 的字段由 Go 类型推断，
+// 见 columnDDL 。多个主键字段合成一个 PRIMARY KEY (a, b) 子句
 func (dbt *DbTable) GetCreateTableSQL() string{
 	t,pk,other,_ := dbt.Extract()
-	if dbdriver.Sqltype == dbdriver.DB_SQLITE{
-		var str = fmt.Sprintf("CREATE TABLE %s (" , t.DbName)
-		for _,ep := range pk {
-			if f, ok := ep.(*exp.Field); ok {
-				if fldTyp,ok := (*dbt.gotype).FieldByName(f.GoName) ; ok{
-					str = fmt.Sprintf(" %s %s %s, ",str,f.DbName,fldTyp.Tag.Get("fieldtype"))
-				}
-			}else{
-				panic(errors.New("create Table failed ,pk field is null"))
-			}
+	var columns = make([]string, 0, len(pk)+len(other))
+	var keys = make([]string, 0, len(pk))
+	for _,ep := range pk {
+		if f, ok := ep.(*exp.Field); ok {
+			columns = append(columns, dbt.columnDDL(f.GoName))
+			keys = append(keys, f.DbName)
+		}else{
+			panic(errors.New("create Table failed ,pk field is null"))
+		}
+	}
+	for _,ep := range other{
+		if f , ok := ep.(*exp.Field);ok{
+			columns = append(columns, dbt.columnDDL(f.GoName))
+		}else{
+			panic(errors.New("create Table failed ,other field is null"))
 		}
+	}
+	//生成pk
+	if len(keys) > 0 {
+		columns = append(columns, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(keys, ", ")))
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s);", t.DbName, strings.Join(columns, ", "))
+}
 
-		for _,ep := range other{
-			if f , ok := ep.(*exp.Field);ok{
-				if fldTyp,ok := (*dbt.gotype).FieldByName(f.GoName) ; ok{
-					str = fmt.Sprintf(" %s %s %s, ",str,f.DbName,fldTyp.Tag.Get("fieldtype"))
-				}
-			}else{
-				panic(errors.New("create Table failed ,other field is null"))
-			}
+// columnDDL 生成建表语句中的一个字段定义。PostgreSQL 下 fieldtype 为空的话按 Go 类型推断，
+// dbgen 的整数主键推断为 serial/bigserial ， fieldtype:"identity" 则生成
+// GENERATED BY DEFAULT AS IDENTITY 。非指针、也不是 NullXxx 的字段加上 NOT NULL
+func (dbt *DbTable) columnDDL(goname string) string {
+	var field, _ = dbt.Fields.GoGet(goname)
+	var ddl = fmt.Sprintf("%s %s", field.DbName, dbt.columnType(goname))
+	if dbt.columnNotNull(goname) {
+		ddl += " NOT NULL"
+	}
+	return ddl + field.constraintDDL()
+}
+
+// columnType 给出字段在建表语句中的类型，SQLite 直接用 fieldtype 标签
+func (dbt *DbTable) columnType(goname string) string {
+	var field, ok = dbt.Fields.GoGet(goname)
+	if !ok {
+		panic(fmt.Errorf("create Table failed ,field %s is not in type", goname))
+	}
+	var fldTyp = field.field
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		return field.DbFieldType
+	}
+	var typ = field.DbFieldType
+	switch typ {
+	case "":
+		switch {
+		case field.Precision > 0 && field.Scale > 0:
+			typ = fmt.Sprintf("numeric(%d,%d)", field.Precision, field.Scale)
+		case field.Precision > 0:
+			typ = fmt.Sprintf("numeric(%d)", field.Precision)
+		case field.Size > 0 && isString(fldTyp.Type):
+			typ = fmt.Sprintf("varchar(%d)", field.Size)
+		default:
+			typ = dbdriver.PgFieldType(fldTyp.Type, field.IsPK && field.DbGen && isInteger(fldTyp.Type), field.IsJson)
 		}
+	case "identity":
+		typ = dbdriver.PgFieldType(fldTyp.Type, false, false) + " GENERATED BY DEFAULT AS IDENTITY"
+	}
+	return typ
+}
 
-		//生成pk
-		for _,ep := range pk {
-			if f, ok := ep.(*exp.Field); ok {
-				str = fmt.Sprintf(" %s %s (%s)",str,"PRIMARY KEY",f.DbName)
-			}else{
-				panic(errors.New("create Table failed ,pk field is null"))
-			}
+// columnNotNull 判断字段在建表语句中是否要加 NOT NULL ，SQLite 的类型和约束都由标签决定，总是 false
+func (dbt *DbTable) columnNotNull(goname string) bool {
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		return false
+	}
+	var field, _ = dbt.Fields.GoGet(goname)
+	var fldTyp = field.field
+	return field.NotNull && !dbdriver.PgNullable(fldTyp.Type)
+}
+
+// constraintDDL 生成字段定义中类型和 NOT NULL 之后的 DEFAULT 、 UNIQUE 、 CHECK 、
+// REFERENCES 部分，外键的目标要等目标类型注册以后才能解析，见 Engine.MapStructTo
+func (field *DbField) constraintDDL() string {
+	var ddl = ""
+	if field.Default != "" {
+		var def = field.Default
+		// SQLite 没有 now() 函数
+		if dbdriver.Sqltype == dbdriver.DB_SQLITE && strings.EqualFold(def, "now()") {
+			def = "CURRENT_TIMESTAMP"
 		}
-		return str + ");"
+		ddl += " DEFAULT " + def
 	}
-	panic(errors.New("current function is not supported by this dbtype"))
-	
+	if field.Unique {
+		ddl += " UNIQUE"
+	}
+	if field.Check != "" {
+		ddl += fmt.Sprintf(" CHECK (%s)", field.Check)
+	}
+	if field.References != "" {
+		if field.RefTable == "" {
+			panic(fmt.Sprintf("references %s of field %s is't a regiested type", field.References, field.GoName))
+		}
+		ddl += fmt.Sprintf(" REFERENCES %s (%s)", field.RefTable, field.RefColumn)
+		if field.OnDelete != "" {
+			ddl += " ON DELETE " + field.OnDelete
+		}
+	}
+	return ddl
+}
+
+// GetCreateIndexSQL 生成 index 和 unique_index 标签声明的建索引语句，按索引名排序。
+// 同名索引的字段按结构中的顺序组成复合索引
+func (dbt *DbTable) GetCreateIndexSQL() []string {
+	var indexes = dbt.indexes()
+	var ret = make([]string, 0, len(indexes))
+	for _, idx := range indexes {
+		ret = append(ret, idx.createSQL(dbt.tablename))
+	}
+	return ret
+}
+
+// index 是 index 和 unique_index 标签声明的一个索引
+type index struct {
+	name    string
+	unique  bool
+	columns []string
+}
+
+func (idx *index) createSQL(tablename string) string {
+	var kind = "INDEX"
+	if idx.unique {
+		kind = "UNIQUE INDEX"
+	}
+	return fmt.Sprintf("CREATE %s IF NOT EXISTS %s ON %s (%s);",
+		kind, idx.name, tablename, strings.Join(idx.columns, ", "))
+}
+
+// indexes 按索引名排序给出表上声明的索引
+func (dbt *DbTable) indexes() []*index {
+	var indexes = make(map[string]*index)
+	var get = func(name string) *index {
+		if idx, ok := indexes[name]; ok {
+			return idx
+		}
+		var idx = &index{name: name}
+		indexes[name] = idx
+		return idx
+	}
+	for _, field := range dbt.ordered {
+		for _, name := range field.Indexes {
+			var idx = get(name)
+			idx.columns = append(idx.columns, field.DbName)
+		}
+		for _, name := range field.UniqueIndexes {
+			var idx = get(name)
+			idx.columns = append(idx.columns, field.DbName)
+			idx.unique = true
+		}
+	}
+	var ret = make([]*index, 0, len(indexes))
+	for _, idx := range indexes {
+		ret = append(ret, idx)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].name < ret[j].name })
+	return ret
+}
+
+// isString 判断字段是否是字符串类型，指针按它指向的类型算
+func isString(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ.Kind() == reflect.String
+}
+
+// isInteger 判断字段是否是整数类型，指针按它指向的类型算
+func isInteger(typ reflect.Type) bool {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
 }
 
 func (dbt *DbTable) DropTable() string{
@@ -469,10 +991,10 @@ func (dbt *DbTable) makeLoads() {
 
 func makeFetchHelper(FieldMap map[string]*DbField) structFetchFunc {
 
-	var refunc = func(rows *sql.Rows, obj interface{}) {
+	var refunc = func(rows *sql.Rows, obj interface{}) error {
 		var cols, err = rows.Columns()
 		if err != nil {
-			panic(err)
+			return err
 		}
 		l := len(cols)
 		var val = reflect.Indirect(reflect.ValueOf(obj))
@@ -480,8 +1002,7 @@ func makeFetchHelper(FieldMap map[string]*DbField) structFetchFunc {
 		var callbacks = make([]func() error, 0, l)
 		for idx, col := range cols {
 			if dbf, ok := FieldMap[col]; ok {
-				var fname = dbf.GoName
-				field := val.FieldByName(fname)
+				field := fieldOf(val, dbf, true)
 				slot, callback := dbf.Extract(field)
 				slots[idx] = slot
 				if callback != nil {
@@ -489,17 +1010,68 @@ func makeFetchHelper(FieldMap map[string]*DbField) structFetchFunc {
 				}
 			}
 		}
-		rows.Scan(slots...)
+		if err := rows.Scan(slots...); err != nil {
+			return err
+		}
 		for _, cb := range callbacks {
-			err := cb()
-			if err != nil {
-				panic(err)
+			if err := cb(); err != nil {
+				return err
 			}
 		}
+		return nil
 	}
 	return refunc
 }
 
+// isAutoTime 判断字段是否需要自动填写当前时间
+func (dbf *DbField) isAutoTime() bool {
+	return dbf.AutoCreate || dbf.AutoUpdate
+}
+
+// isTimeType 判断类型能不能用 setTime 写入时间
+func isTimeType(typ reflect.Type) bool {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	return typ == reflect.TypeOf(time.Time{}) || isNullTime(typ)
+}
+
+func hasString(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
+
+// isNullTime 判断类型是不是 pq.NullTime 、 sql.NullTime 这样带 Time 和 Valid 字段的结构
+func isNullTime(typ reflect.Type) bool {
+	if typ.Kind() != reflect.Struct {
+		return false
+	}
+	var t, ok = typ.FieldByName("Time")
+	var v, valid = typ.FieldByName("Valid")
+	return ok && valid && t.Type == reflect.TypeOf(time.Time{}) && v.Type.Kind() == reflect.Bool
+}
+
+// setTime 把时间写入 time.Time 、 *time.Time 或者 NullTime 类型的字段
+func setTime(field reflect.Value, t time.Time) {
+	switch {
+	case field.Type() == reflect.TypeOf(t):
+		field.Set(reflect.ValueOf(t))
+	case field.Kind() == reflect.Ptr:
+		var ptr = reflect.New(field.Type().Elem())
+		setTime(ptr.Elem(), t)
+		field.Set(ptr)
+	case isNullTime(field.Type()):
+		field.FieldByName("Time").Set(reflect.ValueOf(t))
+		field.FieldByName("Valid").SetBool(true)
+	default:
+		panic(fmt.Sprintf("%v is not a time field", field.Type()))
+	}
+}
+
 func fullGoName(typ reflect.Type) string {
 	return fmt.Sprintf("%s.%s", typ.PkgPath(), typ.Name())
 }
@@ -0,0 +1,182 @@
+package pgears
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+type nameA struct {
+	Name string
+	A    int
+}
+
+type nameB struct {
+	Name string
+	B    int
+}
+
+// bothNames 里 nameA 和 nameB 的 Name 在同一层，有歧义
+type bothNames struct {
+	nameA
+	nameB
+}
+
+type deepName struct {
+	Inner struct{ Name string } `embedded:"inner_"`
+}
+
+type shallowName struct {
+	Name string
+}
+
+type ambiguous struct {
+	ID int `pk:"true"`
+	nameA
+	nameB
+}
+
+type outerWins struct {
+	ID int `pk:"true"`
+	nameA
+	nameB
+	Name string
+}
+
+type nestedAmbiguous struct {
+	ID int `pk:"true"`
+	// bothNames 中的 Name 在第三层有歧义，同一层的 deep.Name 也被遮住
+	bothNames
+	deep
+}
+
+type deep struct {
+	shallowName
+}
+
+type shallowerWins struct {
+	ID int `pk:"true"`
+	bothNames
+	shallowName
+}
+
+func goNames(dbt *DbTable) []string {
+	var names = make([]string, 0, len(dbt.ordered))
+	for _, df := range dbt.ordered {
+		names = append(names, df.GoName)
+	}
+	return names
+}
+
+func TestFlattenAmbiguous(t *testing.T) {
+	var cases = []struct {
+		obj   interface{}
+		names []string
+		index []int
+	}{
+		{ambiguous{}, []string{"ID", "A", "B"}, nil},
+		{outerWins{}, []string{"ID", "A", "B", "Name"}, []int{3}},
+		{nestedAmbiguous{}, []string{"ID", "A", "B"}, nil},
+		{shallowerWins{}, []string{"ID", "A", "B", "Name"}, []int{2, 0}},
+	}
+	for _, c := range cases {
+		var typ = reflect.TypeOf(c.obj)
+		var dbt = NewDbTable(&typ, "t")
+		var names = goNames(dbt)
+		if !reflect.DeepEqual(names, c.names) {
+			t.Errorf("%v: expect fields %v but got %v", typ, c.names, names)
+		}
+		var name, ok = dbt.Fields.GoGet("Name")
+		if ok != (c.index != nil) {
+			t.Errorf("%v: expect Name mapped %v but got %v", typ, c.index != nil, ok)
+		} else if ok && !reflect.DeepEqual(name.Index, c.index) {
+			t.Errorf("%v: expect Name at %v but got %v", typ, c.index, name.Index)
+		}
+		// 不同的类型还是用各自的 Go 方法取字段，确认结果和 Go 的字段提升一致
+		if _, promoted := typ.FieldByName("Name"); promoted != ok {
+			t.Errorf("%v: Name is promoted by go: %v, mapped: %v", typ, promoted, ok)
+		}
+	}
+}
+
+func TestFlattenNamedEmbedded(t *testing.T) {
+	var typ = reflect.TypeOf(deepName{})
+	var dbt = NewDbTable(&typ, "t")
+	var field, ok = dbt.Fields.GoGet("Inner.Name")
+	if !ok || field.DbName != "inner_name" {
+		t.Errorf("expect Inner.Name mapped to inner_name but got %v", field)
+	}
+}
+
+type audit struct {
+	CreatedAt time.Time
+	By        string
+}
+
+type withAudit struct {
+	ID int `pk:"true"`
+	*audit
+}
+
+type Audit struct {
+	CreatedAt time.Time
+	By        string
+}
+
+type withPtrAudit struct {
+	ID int `pk:"true"`
+	*Audit
+}
+
+func TestFieldOfNilPointer(t *testing.T) {
+	var typ = reflect.TypeOf(withPtrAudit{})
+	var dbt = NewDbTable(&typ, "t")
+	var obj = withPtrAudit{ID: 1}
+	var val = reflect.ValueOf(&obj).Elem()
+
+	// 读的时候不分配，拿到零值
+	if arg := dbt.arg(val, "By"); arg != "" {
+		t.Errorf("expect empty string from nil embedded struct but got %#v", arg)
+	}
+	if arg := dbt.arg(val, "CreatedAt"); arg != (time.Time{}) {
+		t.Errorf("expect zero time from nil embedded struct but got %#v", arg)
+	}
+	if obj.Audit != nil {
+		t.Fatal("reading a field should not allocate the embedded struct")
+	}
+
+	// 写的时候分配
+	dbt.fieldValue(val, "By").SetString("me")
+	if obj.Audit == nil || obj.By != "me" {
+		t.Fatalf("expect By written through the embedded pointer but got %+v", obj.Audit)
+	}
+	if arg := dbt.arg(val, "By"); arg != "me" {
+		t.Errorf("expect me but got %#v", arg)
+	}
+}
+
+func TestFlattenUnexportedPointer(t *testing.T) {
+	// 没有导出的结构用指针嵌入的话没办法分配，不映射
+	var typ = reflect.TypeOf(withAudit{})
+	var dbt = NewDbTable(&typ, "t")
+	if names := goNames(dbt); !reflect.DeepEqual(names, []string{"ID"}) {
+		t.Errorf("expect only ID but got %v", names)
+	}
+}
+
+type secret struct {
+	ID       int `pk:"true"`
+	Password string
+	Version  int `version:"true"`
+}
+
+func TestStaleObjectMessage(t *testing.T) {
+	var typ = reflect.TypeOf(secret{})
+	var dbt = NewDbTable(&typ, "secret")
+	var obj = secret{ID: 7, Password: "hunter2", Version: 3}
+	var err = NewStaleObject(dbt, reflect.ValueOf(&obj).Elem())
+	var expect = "github.com/Dwarfartisan/pgears.secret(ID=7) is stale, it has been modified or deleted"
+	if err.Error() != expect {
+		t.Errorf("expect %s but got %s", expect, err.Error())
+	}
+}
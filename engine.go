@@ -3,11 +3,13 @@
 package pgears
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/Dwarfartisan/pgears/dbdriver"
 	"github.com/Dwarfartisan/pgears/exp"
@@ -47,6 +49,70 @@ type Engine struct {
 	tablemap map[string]*DbTable
 	gomap    map[reflect.Type]*DbTable
 	gonmap   map[string]*DbTable
+	// Track 记录的对象快照
+	tracker *tracker
+	// unscoped 为 true 时不过滤软删除的行，见 Unscoped
+	unscoped bool
+	// clock 用于 autocreate/autoupdate 和软删除的时间戳，测试时可以用 SetClock 替换
+	clock func() time.Time
+	// interceptors 是执行 SQL 的拦截器链，见 Use
+	interceptors []Interceptor
+	// naming 是省略表名和 field 标签时的命名规则，见 SetNamingStrategy
+	naming NamingStrategy
+	// ctx 是通过这个 Engine 做的操作使用的 context ，为 nil 时用 context.Background ，见 WithContext
+	ctx context.Context
+}
+
+func newEngine(conn *sql.DB) *Engine {
+	return &Engine{DB: conn,
+		tablemap: make(map[string]*DbTable),
+		gomap:    make(map[reflect.Type]*DbTable),
+		gonmap:   make(map[string]*DbTable),
+		tracker:  newTracker(),
+		clock:    time.Now,
+		naming:   SnakeCase,
+	}
+}
+
+// SetClock 替换 Engine 取当前时间的函数，主要用于测试
+func (e *Engine) SetClock(clock func() time.Time) {
+	e.clock = clock
+}
+
+// touch 把 obj 中非 dbgen 的 autoupdate 字段设为当前时间， create 为 true 时 autocreate
+// 字段也一样。dbgen 的字段由数据库端的 CURRENT_TIMESTAMP 生成，这里不管
+func (e *Engine) touch(m *DbTable, val reflect.Value, create bool) {
+	var now = e.clock()
+	for _, key := range m.NDbGen.GoKeys() {
+		var dbf, _ = m.NDbGen.GoGet(key)
+		if dbf.AutoUpdate || (create && dbf.AutoCreate) {
+			setTime(m.fieldValue(val, key), now)
+		}
+	}
+}
+
+// Unscoped 返回一个共享连接和类型注册信息的 Engine ，通过它做的 Fetch 、 Update 等操作
+// 不再排除软删除的行， Delete 也会真正的删除数据
+func (e *Engine) Unscoped() *Engine {
+	var unscoped = *e
+	unscoped.unscoped = true
+	return &unscoped
+}
+
+// WithContext 返回一个共享连接和类型注册信息的 Engine ，通过它做的操作都带上 ctx ，
+// 拦截器从 Call.Context 、钩子从参数里拿到的就是它，追踪的 span 也就挂在 ctx 的 span 下面
+func (e *Engine) WithContext(ctx context.Context) *Engine {
+	var scoped = *e
+	scoped.ctx = ctx
+	return &scoped
+}
+
+// Context 返回 Engine 的操作使用的 context ，没有用 WithContext 指定的话是 context.Background
+func (e *Engine) Context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+	return e.ctx
 }
 
 // CreateEngine 方法构造一个新的 Engine 对象，error 不为空的话表示构造过程出错。
@@ -66,10 +132,7 @@ func CreateEngine(url string) (*Engine, error) {
 				return nil, err
 			}
 
-			return &Engine{conn, make(map[string]*DbTable),
-				make(map[reflect.Type]*DbTable),
-				make(map[string]*DbTable),
-			}, nil
+			return newEngine(conn), nil
 		}
 	case constr[0] == "postgres":
 		{
@@ -79,10 +142,7 @@ func CreateEngine(url string) (*Engine, error) {
 				return nil, err
 			}
 
-			return &Engine{conn, make(map[string]*DbTable),
-				make(map[reflect.Type]*DbTable),
-				make(map[string]*DbTable),
-			}, nil
+			return newEngine(conn), nil
 		}
 	default:
 		return nil, errors.New("current database is not supported")
@@ -99,12 +159,11 @@ func (e *Engine) PrepareFor(typeName string, exp exp.Exp) (*Query, error) {
 	if table, ok := e.gonmap[typeName]; ok {
 		var parser = NewParser(e)
 		var sql = exp.Eval(parser)
-		//fmt.Println(sql)
-		var stmt, err = e.DB.Prepare(sql)
+		var stmt, err = e.prepare(e, "PrepareFor", *table.gotype, sql)
 		if err != nil {
 			return nil, err
 		}
-		return &Query{stmt, table}, nil
+		return &Query{stmt, table, sql, e, false}, nil
 	}
 	message := typeName + " not found"
 	panic(message)
@@ -117,10 +176,15 @@ func (e *Engine) CreateTable(typeName string) error {
 	if table, ok := e.gonmap[typeName]; ok {
 		sql := table.GetCreateTableSQL()
 
-		var _, err = e.DB.Exec(sql)
+		var _, err = e.exec(e, "CreateTable", *table.gotype, sql, nil, nil)
 		if err != nil {
 			return err
 		}
+		for _, sql := range table.GetCreateIndexSQL() {
+			if _, err := e.exec(e, "CreateIndex", *table.gotype, sql, nil, nil); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 	message := typeName + " not found"
@@ -132,7 +196,7 @@ func (e *Engine) CreateTable(typeName string) error {
 func (e *Engine) DropTable(typeName string) error {
 	if table, ok := e.gonmap[typeName]; ok {
 		sql := table.DropTable()
-		var _, err = e.DB.Exec(sql)
+		var _, err = e.exec(e, "DropTable", *table.gotype, sql, nil, nil)
 		if err != nil {
 			return err
 		}
@@ -147,8 +211,7 @@ func (e *Engine) DropTable(typeName string) error {
 func (e *Engine) PrepareSQL(exp exp.Exp) (*sql.Stmt, error) {
 	var parser = NewParser(e)
 	var sql = exp.Eval(parser)
-	// fmt.Println(sql)
-	return e.DB.Prepare(sql)
+	return e.prepare(e, "PrepareSQL", nil, sql)
 }
 
 // 将类型映射到明确指定的表，遵循一个简单的规则：
@@ -160,18 +223,26 @@ func (e *Engine) PrepareSQL(exp exp.Exp) (*sql.Stmt, error) {
 // - tag 包含 jsonto:"map" 的 映射到 map[string]interface{}
 // - tag 包含 jsonto:"struct" 的映射到结构，具体的结构类型是一个 reflect.Type,
 // 保存在 DbField 类型的 gotype 字段
+// - tag 包含 jsonto:"any" 的映射到 interface{} ，JSON 数组和标量也能存取
 // - 如果字段定义为值类型，表示对应的是 not null
 // - 如果定义为指针类型，表示对应的是可以为null的字段，读取后的使用应该谨慎
-// - tag 中的 field:"xxxx" 指定了对应的数据库子段名，这个不能省，一定要写。
-// 没做自动转换真的不是因为懒……相信我……
+// - tag 中的 field:"xxxx" 指定了对应的数据库子段名，省略的话按 Engine 的命名规则从字段名
+// 转换，默认是 snake_case ，见 SetNamingStrategy 。 field:"-" 的字段不映射
+// - tablename 为空的话同样按命名规则从类型名转换
+// - 匿名嵌入的结构（包括指针）展开成它的字段，具名的结构字段加上 embedded:"addr_" 标签也会
+// 展开，标签的值是字段名的前缀。没有导出的字段不映射
 func (e *Engine) MapStructTo(s interface{}, tablename string) {
 	var val = reflect.ValueOf(s)
 	var typ = val.Type().Elem()
-	var table = NewDbTable(&typ, tablename)
+	if tablename == "" {
+		tablename = e.naming.TableName(typ.Name())
+	}
+	var table = newDbTable(&typ, tablename, e.naming)
 	e.tablemap[tablename] = table
 	e.gomap[typ] = table
 	var fullname = fmt.Sprintf("%s.%s", typ.PkgPath(), typ.Name())
 	e.gonmap[fullname] = table
+	e.resolveReferences()
 }
 
 // 将类型注册到指定的表上，这个操作不要求类型完全匹配表结构，只要部分符合，主键完整即可
@@ -183,10 +254,48 @@ func (e *Engine) MapStructTo(s interface{}, tablename string) {
 func (e *Engine) RegistStruct(s interface{}, tablename string) {
 	var val = reflect.ValueOf(s)
 	var typ = val.Type().Elem()
-	var table = NewDbTable(&typ, tablename)
+	if tablename == "" {
+		tablename = e.naming.TableName(typ.Name())
+	}
+	var table = newDbTable(&typ, tablename, e.naming)
 	e.gomap[typ] = table
 	var fullname = fullGoName(typ)
 	e.gonmap[fullname] = table
+	e.resolveReferences()
+}
+
+// resolveReferences 给所有还没解析的 references 标签找到目标表和字段。外键的目标类型可能
+// 在后面才注册，所以每注册一个类型都要再来一遍。 references 的格式是 pkg.Type.Field ，
+// pkg 可以是完整的包路径，也可以只写包名，跟当前类型同一个包的话可以省略
+func (e *Engine) resolveReferences() {
+	for _, table := range e.gomap {
+		for _, field := range table.Fields.gomap {
+			if field.References == "" || field.RefTable != "" {
+				continue
+			}
+			var dot = strings.LastIndex(field.References, ".")
+			if dot < 0 {
+				panic(fmt.Sprintf("references %s of field %s should be like pkg.Type.Field",
+					field.References, field.GoName))
+			}
+			var typeRef, goname = field.References[:dot], field.References[dot+1:]
+			if !strings.Contains(typeRef, ".") {
+				typeRef = fmt.Sprintf("%s.%s", (*table.gotype).PkgPath(), typeRef)
+			}
+			for fullname, target := range e.gonmap {
+				if fullname != typeRef && !strings.HasSuffix(fullname, "/"+typeRef) {
+					continue
+				}
+				if ref, ok := target.Fields.GoGet(goname); ok {
+					field.RefTable = target.tablename
+					field.RefColumn = ref.DbName
+				} else {
+					panic(fmt.Sprintf("field %s has't been found in table %s", goname, target.tablename))
+				}
+				break
+			}
+		}
+	}
 }
 
 // Type Name to Table Name
@@ -221,38 +330,56 @@ func (e *Engine) FinaToCona(typename string, fieldname string) string {
 // 目前操作匿名类型可以先拼接一个 Exp ，然后让Engine 去 prepare 出对应的 Query，
 // 然后用 Query 和 Result 操作
 func (e *Engine) Fetch(obj interface{}) error {
+	return e.fetch(e, obj, false)
+}
+
+// FetchForUpdate 在给定的事务中按主键加载 obj ，同时用 SELECT ... FOR UPDATE 锁住这一行，
+// 直到事务结束。SQLite 没有行锁，这时它跟在事务里 Fetch 没有区别
+func (e *Engine) FetchForUpdate(tran *Tran, obj interface{}) error {
+	return e.fetch(tran.Tx, obj, true)
+}
+
+// fetch 是 Fetch 和 FetchForUpdate 的共同实现， exec 决定语句在连接池还是事务上执行
+func (e *Engine) fetch(exec Executor, obj interface{}, forUpdate bool) error {
 	var typ = reflect.TypeOf(obj).Elem()
 	if m, ok := e.gomap[typ]; ok {
-		var tabl, pk, fs, cond = m.Extract()
+		var tabl, pk, fs, cond = m.extract(e.unscoped)
 		var sel = exp.Select(fs...).From(tabl).Where(cond)
+		if forUpdate {
+			sel.ForUpdate()
+		}
 		var parser = NewParser(e)
 		var sql = sel.Eval(parser)
-		stmt, err := e.Prepare(sql)
-		defer stmt.Close()
-		if err != nil {
-			return err
-		}
 		var args = make([]interface{}, 0)
+		var names = make([]string, 0)
 		// 因为要填充，无论如何这里也要传入一个指针，不是指针的请自觉panic……
 		var val = reflect.ValueOf(obj).Elem()
 		for _, p := range pk {
 			if pf, ok := p.(*exp.Field); ok {
-				var field, _ = typ.FieldByName(pf.GoName)
-				var arg interface{} = ExtractField(val.FieldByName(pf.GoName), field)
+				var arg interface{} = m.arg(val, pf.GoName)
 				args = append(args, arg)
+				names = append(names, pf.GoName)
 			}
 		}
-		rset, err := stmt.Query(args...)
-		defer rset.Close()
+		var op = "Fetch"
+		if forUpdate {
+			op = "FetchForUpdate"
+		}
+		rset, err := e.query(exec, op, typ, sql, args, names)
 		if err != nil {
 			return err
 		}
-		if rset.Next() {
-			m.npk(rset, obj)
-		} else {
+		defer rset.Close()
+		if !rset.Next() {
+			if err := rset.Err(); err != nil {
+				return err
+			}
 			return NewNotFound(obj)
 		}
-		return nil
+		if err := m.npk(rset, obj); err != nil {
+			return err
+		}
+		return afterFetch(e.Context(), obj, exec)
 	} else {
 		var message = fmt.Sprintf("%v.%v is't a regiested type",
 			typ.PkgPath(), typ.Name())
@@ -264,38 +391,21 @@ func (e *Engine) Fetch(obj interface{}) error {
 func (e *Engine) Insert(obj interface{}) error {
 	var typ = reflect.TypeOf(obj).Elem()
 	if m, ok := e.gomap[typ]; ok {
-		var tabl, pk, fs, _ = m.Extract()
-		for _, p := range pk {
-			fs = append(fs, p)
+		if err := beforeInsert(e.Context(), obj, e); err != nil {
+			return err
 		}
-		var ins = exp.Insert(tabl, fs...)
+		var ins, names = m.AllInsertExpr()
 		var parser = NewParser(e)
 		var sql = ins.Eval(parser)
-		//fmt.Println(sql)
-		var stmt, err = e.Prepare(sql)
-		defer stmt.Close()
-
-		if err != nil {
-			//fmt.Println(err)
-			return err
-		}
-		var l = len(pk)
-		var args = make([]interface{}, 0, l)
 		// 因为要填充，无论如何这里也要传入一个指针，不是指针的请自觉panic……
 		var val = reflect.ValueOf(obj).Elem()
-		for _, f := range fs {
-			if f, ok := f.(*exp.Field); ok {
-				var field, _ = typ.FieldByName(f.GoName)
-				var arg interface{} = ExtractField(val.FieldByName(f.GoName), field)
-				args = append(args, arg)
-			}
-		}
-		_, err = stmt.Exec(args...)
+		e.touch(m, val, true)
+		var _, err = e.exec(e, "Insert", typ, sql, fieldArgs(m, val, names), names)
 		if err != nil {
 			return err
 		}
 		// 因为是完全从应用层取数据，也就不存在对返回结果集的处理，但是这里其实应该校验操作行数
-		return nil
+		return afterInsert(e.Context(), obj, e)
 	} else {
 		var message = fmt.Sprintf("%v is't a regiested type",
 			fullGoName(typ))
@@ -308,33 +418,32 @@ func (e *Engine) Insert(obj interface{}) error {
 func (e *Engine) InsertMerge(obj interface{}) error {
 	var typ = reflect.TypeOf(obj).Elem()
 	if m, ok := e.gomap[typ]; ok {
+		if err := beforeInsert(e.Context(), obj, e); err != nil {
+			return err
+		}
 		var ins, names = m.MergeInsertExpr()
 		var parser = NewParser(e)
 		var sql = ins.Eval(parser)
-		var stmt, err = e.Prepare(sql)
-		defer stmt.Close()
-		if err != nil {
-			return err
-		}
 		var l = len(names)
 		var args = make([]interface{}, 0, l)
 		// 因为要填充，无论如何这里也要传入一个指针，不是指针的请自觉panic……
 		var val = reflect.ValueOf(obj).Elem()
+		e.touch(m, val, true)
 		for _, name := range names {
-			var field, _ = typ.FieldByName(name)
-			var arg interface{} = ExtractField(val.FieldByName(name), field)
+			var arg interface{} = m.arg(val, name)
 			args = append(args, arg)
 		}
-		rset, err := stmt.Query(args...)
-		defer rset.Close()
+		rset, err := e.query(e, "InsertMerge", typ, sql, args, names)
 		if err != nil {
-			//fmt.Println(err)
 			return err
 		}
+		defer rset.Close()
 		if rset.Next() {
-			m.returning(rset, obj)
+			if err := m.returning(rset, obj); err != nil {
+				return err
+			}
 		}
-		return nil
+		return afterInsert(e.Context(), obj, e)
 	} else {
 		var message = fmt.Sprintf("%s is't a regiested type",
 			fullGoName(typ))
@@ -344,91 +453,369 @@ func (e *Engine) InsertMerge(obj interface{}) error {
 
 // update 当前的设定是直接更新，所以无返回，但是——
 // TODO:如果返回的受影响数据不为一，记一个warning ，发一个error
+// 带 version 字段的类型会检查版本号，不匹配的话返回 StaleObject
 func (e *Engine) Update(obj interface{}) error {
 	var typ = reflect.TypeOf(obj).Elem()
 	if m, ok := e.gomap[typ]; ok {
+		if err := beforeUpdate(e.Context(), obj, e); err != nil {
+			return err
+		}
 		// 因为要填充，无论如何这里也要传入一个指针，不是指针的请自觉panic……
 		var val = reflect.ValueOf(obj).Elem()
-		//var typ = val.Type()
-		var tabl, pk, fs, cond = m.Extract()
-		var args = make([]interface{}, 0, len(pk)+len(fs))
-		var set = make([]exp.Exp, 0, len(fs))
-		var step = len(fs)
-		exp.IncOrder(cond, step)
-		for idx, f := range fs {
-			set = append(set, exp.Equal(f, exp.Arg(idx+1)))
-			if fs, ok := f.(*exp.Field); ok {
-				var field, _ = typ.FieldByName(fs.GoName)
-				var arg interface{} = ExtractField(val.FieldByName(fs.GoName), field)
-				args = append(args, arg)
-			}
+		var rollback = keepFields(m, val)
+		e.touch(m, val, false)
+		var upd, args, names = updateExpr(m, val, e.unscoped)
+		var parser = NewParser(e)
+		var sql = upd.Eval(parser)
+		res, err := e.exec(e, "Update", typ, sql, args, names)
+		if err != nil {
+			rollback()
+			return err
 		}
-		for _, p := range pk {
-			if pf, ok := p.(*exp.Field); ok {
-				var field, _ = typ.FieldByName(pf.GoName)
-				var arg interface{} = ExtractField(val.FieldByName(pf.GoName), field)
-				args = append(args, arg)
+		if err := checkVersion(m, val, res); err != nil {
+			rollback()
+			return err
+		}
+		return afterUpdate(e.Context(), obj, e)
+	} else {
+		var message = fmt.Sprintf("%v is't a regiested type",
+			fullGoName(typ))
+		return errors.New(message)
+	}
+}
+
+// UpdateReturning 跟 Update 一样按主键更新所有非主键字段，然后通过 RETURNING 把更新后的
+// 整行加载回 obj ，这样触发器在数据库端改写的字段（例如 updated_at ）也能同步回来。
+// 没有更新到任何行的话返回 NotFound
+func (e *Engine) UpdateReturning(obj interface{}) error {
+	var typ = reflect.TypeOf(obj).Elem()
+	if m, ok := e.gomap[typ]; ok {
+		if err := beforeUpdate(e.Context(), obj, e); err != nil {
+			return err
+		}
+		var val = reflect.ValueOf(obj).Elem()
+		var rollback = keepFields(m, val)
+		e.touch(m, val, false)
+		var upd, args, names = updateExpr(m, val, e.unscoped)
+		var _, pk, fs, _ = m.Extract()
+		upd.Returning(append(pk, fs...)...)
+		var err = e.loadReturning("UpdateReturning", upd, args, names, m.all, obj)
+		if err != nil {
+			rollback()
+		}
+		if _, ok := err.(NotFound); ok && m.Version != nil {
+			return NewStaleObject(m, val)
+		} else if err != nil {
+			return err
+		}
+		return afterUpdate(e.Context(), obj, e)
+	} else {
+		var message = fmt.Sprintf("%v is't a regiested type",
+			fullGoName(typ))
+		return errors.New(message)
+	}
+}
+
+// UpdateFields 只更新 obj 中给定的字段（Go 结构字段名），其它字段保持数据库中的值不变，
+// 这样不会覆盖别人同时修改的、我们没有碰过的字段
+func (e *Engine) UpdateFields(obj interface{}, fields ...string) error {
+	if err := beforeUpdate(e.Context(), obj, e); err != nil {
+		return err
+	}
+	if err := e.updateFields(obj, fields...); err != nil {
+		return err
+	}
+	return afterUpdate(e.Context(), obj, e)
+}
+
+// updateFields 是不调用钩子的 UpdateFields ，软删除也要用到它
+func (e *Engine) updateFields(obj interface{}, fields ...string) error {
+	var typ = reflect.TypeOf(obj).Elem()
+	if m, ok := e.gomap[typ]; ok {
+		if len(fields) == 0 {
+			return nil
+		}
+		for _, name := range fields {
+			if dbf, ok := m.Fields.GoGet(name); !ok {
+				return fmt.Errorf("field %s has't been found in table %s", name, m.tablename)
+			} else if dbf.IsPK {
+				return fmt.Errorf("field %s is a primary key and can't be updated", name)
 			}
 		}
-		var upd = exp.Update(tabl).Set(set...).Where(cond)
+		var val = reflect.ValueOf(obj).Elem()
+		var rollback = keepFields(m, val)
+		e.touch(m, val, false)
+		var upd, names = m.updateExpr(fields, e.unscoped)
+		var args = fieldArgs(m, val, names)
 		var parser = NewParser(e)
 		var sql = upd.Eval(parser)
-		var stmt, err = e.Prepare(sql)
-		defer stmt.Close()
+		res, err := e.exec(e, "UpdateFields", typ, sql, args, names)
+		if err == nil {
+			err = checkVersion(m, val, res)
+		}
 		if err != nil {
-			return err
+			rollback()
 		}
-		stmt.Exec(args...)
+		return err
 	} else {
 		var message = fmt.Sprintf("%v is't a regiested type",
 			fullGoName(typ))
 		return errors.New(message)
 	}
+}
+
+// updateExpr 生成按主键更新所有非主键字段的表达式和对应的参数。 dbgen 的字段由数据库
+// 生成， autocreate 的字段只在插入时写入，都不出现在 SET 里
+func updateExpr(m *DbTable, val reflect.Value, unscoped bool) (*exp.Upd, []interface{}, []string) {
+	var sets = make([]string, 0, m.NPk.Length())
+	for _, key := range m.NPk.GoKeys() {
+		var dbf, _ = m.NPk.GoGet(key)
+		if !dbf.DbGen && !dbf.AutoCreate {
+			sets = append(sets, key)
+		}
+	}
+	var upd, names = m.updateExpr(sets, unscoped)
+	return upd.(*exp.Upd), fieldArgs(m, val, names), names
+}
+
+// fieldArgs 按照参数命名表从结构中提取参数
+func fieldArgs(m *DbTable, val reflect.Value, names []string) []interface{} {
+	var args = make([]interface{}, 0, len(names))
+	for _, name := range names {
+		args = append(args, m.arg(val, name))
+	}
+	return args
+}
+
+// checkVersion 在带 version 字段的表上检查 update 的结果，没有更新到任何行说明对象已经被
+// 别人修改或删除了，返回 StaleObject ，否则把结构中的版本号加一，跟数据库保持一致
+func checkVersion(m *DbTable, val reflect.Value, res sql.Result) error {
+	if m.Version == nil {
+		return nil
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return NewStaleObject(m, val)
+	}
+	var version = m.fieldValue(val, m.Version.GoName)
+	switch version.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		version.SetInt(version.Int() + 1)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		version.SetUint(version.Uint() + 1)
+	}
 	return nil
 }
 
 // Delete 当前的设定是根据pk删除，所以无返回，但是——
 // TODO:如果返回的受影响数据为0，记一个warning ，发一个error
 // 如果大于1，应该log一个Fail，发一个error，必要的话panic也是可以的……
+// 带 softdelete 字段的类型不会真的删除，而是把这个字段设为当前时间，要真正删除请用 HardDelete
 func (e *Engine) Delete(obj interface{}) error {
 	var typ = reflect.TypeOf(obj).Elem()
 	if m, ok := e.gomap[typ]; ok {
+		if err := beforeDelete(e.Context(), obj, e); err != nil {
+			return err
+		}
 		// 因为要填充，无论如何这里也要传入一个指针，不是指针的请自觉panic……
 		var val = reflect.ValueOf(obj).Elem()
-		var tabl, pk, _, cond = m.Extract()
-		var del = exp.Delete(tabl).Where(cond)
+		if m.SoftDelete != nil && !e.unscoped {
+			if err := e.softDelete(m, val, e.clock(), obj); err != nil {
+				return err
+			}
+			return afterDelete(e.Context(), obj, e)
+		}
+		var del, args, names = deleteExpr(m, val, e.unscoped)
 		var parser = NewParser(e)
 		var sql = del.Eval(parser)
-		var stmt, err = e.Prepare(sql)
-		defer stmt.Close()
+		res, err := e.exec(e, "Delete", typ, sql, args, names)
 		if err != nil {
 			return err
 		}
-		var args = make([]interface{}, 0, len(pk))
-		for _, p := range pk {
-			if pf, ok := p.(*exp.Field); ok {
-				var field, _ = typ.FieldByName(pf.GoName)
-				var arg interface{} = ExtractField(val.FieldByName(pf.GoName), field)
-				args = append(args, arg)
+		if m.Version != nil {
+			if affected, err := res.RowsAffected(); err != nil {
+				return err
+			} else if affected == 0 {
+				return NewStaleObject(m, val)
 			}
 		}
-		stmt.Exec(args...)
 	} else {
 		var message = fmt.Sprintf("%v is't a regiested type",
 			fullGoName(typ))
 		return errors.New(message)
 	}
+	return afterDelete(e.Context(), obj, e)
+}
+
+// HardDelete 真正的删除 obj 对应的行，不管它是否带有 softdelete 字段，或者已经被软删除
+func (e *Engine) HardDelete(obj interface{}) error {
+	return e.Unscoped().Delete(obj)
+}
+
+// Restore 恢复一个软删除的对象，即把它的 softdelete 字段设回 null
+func (e *Engine) Restore(obj interface{}) error {
+	var typ = reflect.TypeOf(obj).Elem()
+	if m, ok := e.gomap[typ]; ok {
+		if m.SoftDelete == nil {
+			return fmt.Errorf("%v has no softdelete field", fullGoName(typ))
+		}
+		var val = reflect.ValueOf(obj).Elem()
+		var rollback = keepFields(m, val, m.SoftDelete.GoName)
+		var field = m.fieldValue(val, m.SoftDelete.GoName)
+		field.Set(reflect.Zero(field.Type()))
+		if err := e.Unscoped().updateFields(obj, m.SoftDelete.GoName); err != nil {
+			rollback()
+			return err
+		}
+		return nil
+	} else {
+		var message = fmt.Sprintf("%v is't a regiested type",
+			fullGoName(typ))
+		return errors.New(message)
+	}
+}
+
+// softDelete 把 softdelete 字段设为 now 并写回数据库，失败的话恢复结构中原来的值
+func (e *Engine) softDelete(m *DbTable, val reflect.Value, now time.Time, obj interface{}) error {
+	var rollback = keepFields(m, val, m.SoftDelete.GoName)
+	setTime(m.fieldValue(val, m.SoftDelete.GoName), now)
+	if err := e.updateFields(obj, m.SoftDelete.GoName); err != nil {
+		rollback()
+		return err
+	}
 	return nil
 }
 
+// keepFields 记下 names 给出的字段和 touch 会改写的 autoupdate 字段现在的值，返回的函数
+// 把它们都恢复回去，更新、软删除和恢复写库失败的时候，结构要跟数据库里的保持一致
+func keepFields(m *DbTable, val reflect.Value, names ...string) func() {
+	var kept = make(map[string]bool, len(names))
+	for _, name := range names {
+		kept[name] = true
+	}
+	for _, key := range m.NDbGen.GoKeys() {
+		var dbf, _ = m.NDbGen.GoGet(key)
+		if dbf.AutoUpdate && !kept[key] {
+			names = append(names, key)
+		}
+	}
+	var fields = make([]reflect.Value, 0, len(names))
+	var olds = make([]reflect.Value, 0, len(names))
+	for _, name := range names {
+		var field = m.fieldValue(val, name)
+		fields = append(fields, field)
+		olds = append(olds, reflect.ValueOf(field.Interface()))
+	}
+	return func() {
+		for idx, field := range fields {
+			field.Set(olds[idx])
+		}
+	}
+}
+
+// DeleteReturning 按主键删除 obj 对应的行，并且把删除前的整行通过 RETURNING 加载回 obj ，
+// 没有删除任何行的话返回 NotFound 。带 softdelete 字段的类型同样只做软删除
+func (e *Engine) DeleteReturning(obj interface{}) error {
+	var typ = reflect.TypeOf(obj).Elem()
+	if m, ok := e.gomap[typ]; ok {
+		if err := beforeDelete(e.Context(), obj, e); err != nil {
+			return err
+		}
+		var val = reflect.ValueOf(obj).Elem()
+		var expr exp.Exp
+		var args []interface{}
+		var names []string
+		var _, pk, fs, _ = m.Extract()
+		if m.SoftDelete != nil && !e.unscoped {
+			var rollback = keepFields(m, val, m.SoftDelete.GoName)
+			setTime(m.fieldValue(val, m.SoftDelete.GoName), e.clock())
+			e.touch(m, val, false)
+			var upd exp.Exp
+			upd, names = m.updateExpr([]string{m.SoftDelete.GoName}, false)
+			args = fieldArgs(m, val, names)
+			rollback()
+			expr = upd.(*exp.Upd).Returning(append(pk, fs...)...)
+		} else {
+			var del *exp.Del
+			del, args, names = deleteExpr(m, val, e.unscoped)
+			expr = del.Returning(append(pk, fs...)...)
+		}
+		var err = e.loadReturning("DeleteReturning", expr, args, names, m.all, obj)
+		if _, ok := err.(NotFound); ok && m.Version != nil {
+			return NewStaleObject(m, val)
+		} else if err != nil {
+			return err
+		}
+		return afterDelete(e.Context(), obj, e)
+	} else {
+		var message = fmt.Sprintf("%v is't a regiested type",
+			fullGoName(typ))
+		return errors.New(message)
+	}
+}
+
+// deleteExpr 生成按主键删除的表达式和对应的参数，带 version 字段的表还要匹配版本号
+func deleteExpr(m *DbTable, val reflect.Value, unscoped bool) (*exp.Del, []interface{}, []string) {
+	var tabl, pk, _, cond = m.extract(unscoped)
+	var args = make([]interface{}, 0, len(pk)+1)
+	var names = make([]string, 0, len(pk)+1)
+	for _, p := range pk {
+		if pf, ok := p.(*exp.Field); ok {
+			var arg interface{} = m.arg(val, pf.GoName)
+			args = append(args, arg)
+			names = append(names, pf.GoName)
+		}
+	}
+	if m.Version != nil {
+		var name = m.Version.GoName
+		args = append(args, m.arg(val, name))
+		names = append(names, name)
+		cond = exp.And(cond, exp.Equal(tabl.Field(name), exp.Arg(len(args))))
+	}
+	return exp.Delete(tabl).Where(cond), args, names
+}
+
+// loadReturning 执行一个带 RETURNING 的语句，用 load 把返回的第一行填充到 obj ，
+// 没有返回任何行的话返回 NotFound
+func (e *Engine) loadReturning(op string, expr exp.Exp, args []interface{}, names []string,
+	load structFetchFunc, obj interface{}) error {
+	var parser = NewParser(e)
+	var sql = expr.Eval(parser)
+	rset, err := e.query(e, op, reflect.TypeOf(obj).Elem(), sql, args, names)
+	if err != nil {
+		return err
+	}
+	defer rset.Close()
+	if rset.Next() {
+		return load(rset, obj)
+	}
+	if err := rset.Err(); err != nil {
+		return err
+	}
+	return NewNotFound(obj)
+}
+
 // 用于类似 select count(*) from table where cond 这种只需要获取单个结果的查询
 // 程序逻辑直接获取单行的第一列，如果查询实际返回的结果集格式不匹配……大概会出错……吧……
 func (engine *Engine) Scalar(expr exp.Exp, args ...interface{}) (interface{}, error) {
 	var parser = NewParser(engine)
-	var sql = expr.Eval(parser)
-	var row = engine.QueryRow(sql, args...)
+	var query = expr.Eval(parser)
+	rows, err := engine.query(engine, "Scalar", nil, query, args, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, sql.ErrNoRows
+	}
 	var data interface{}
-	var err = row.Scan(&data)
+	err = rows.Scan(&data)
 	return data, err
 }
 
@@ -456,9 +843,9 @@ func (engine *Engine) AutoTran(fun func(*Engine, *Tran) (interface{}, error)) (i
 	return re, nil
 }
 
-// Begin 返回一个封装后的事务对象
+// Begin 返回一个封装后的事务对象，事务绑定在 Engine 的 context 上，见 WithContext
 func (engine *Engine) Begin() (*Tran, error) {
-	tx, err := engine.DB.Begin()
+	tx, err := engine.DB.BeginTx(engine.Context(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -474,7 +861,7 @@ type Tran struct {
 // Query 将一个给定的Query转为事务Query，作用类似 sql.Tx 的 Stmt 方法
 func (tran *Tran) Query(query *Query) *Query {
 	stmt := tran.Stmt(query.Stmt)
-	return &Query{stmt, query.table}
+	return &Query{stmt, query.table, query.sql, query.db, true}
 }
 
 //带有事务的版本
@@ -482,39 +869,22 @@ func (tran *Tran) Query(query *Query) *Query {
 func (tran *Tran) Insert(obj interface{}) error {
 	var typ = reflect.TypeOf(obj).Elem()
 	if m, ok := tran.db.gomap[typ]; ok {
-		var tabl, pk, fs, _ = m.Extract()
-		for _, p := range pk {
-			fs = append(fs, p)
+		if err := beforeInsert(tran.db.Context(), obj, tran.Tx); err != nil {
+			return err
 		}
-		var ins = exp.Insert(tabl, fs...)
+		var ins, names = m.AllInsertExpr()
 		var parser = NewParser(tran.db)
 
 		var sql = ins.Eval(parser)
-		//fmt.Println(sql)
-		var stmt, err = tran.Prepare(sql)
-
-		defer stmt.Close()
-
-		if err != nil {
-			return err
-		}
-		var l = len(pk)
-		var args = make([]interface{}, 0, l)
 		// 因为要填充，无论如何这里也要传入一个指针，不是指针的请自觉panic……
 		var val = reflect.ValueOf(obj).Elem()
-		for _, f := range fs {
-			if f, ok := f.(*exp.Field); ok {
-				var field, _ = typ.FieldByName(f.GoName)
-				var arg interface{} = ExtractField(val.FieldByName(f.GoName), field)
-				args = append(args, arg)
-			}
-		}
-		_, err = stmt.Exec(args...)
+		tran.db.touch(m, val, true)
+		var _, err = tran.db.exec(tran.Tx, "Insert", typ, sql, fieldArgs(m, val, names), names)
 		if err != nil {
 			return err
 		}
 		// 因为是完全从应用层取数据，也就不存在对返回结果集的处理，但是这里其实应该校验操作行数
-		return nil
+		return afterInsert(tran.db.Context(), obj, tran.Tx)
 	} else {
 		var message = fmt.Sprintf("%v is't a regiested type",
 			fullGoName(typ))
@@ -527,32 +897,32 @@ func (tran *Tran) Insert(obj interface{}) error {
 func (tran *Tran) InsertMerge(obj interface{}) error {
 	var typ = reflect.TypeOf(obj).Elem()
 	if m, ok := tran.db.gomap[typ]; ok {
+		if err := beforeInsert(tran.db.Context(), obj, tran.Tx); err != nil {
+			return err
+		}
 		var ins, names = m.MergeInsertExpr()
 		var parser = NewParser(tran.db)
 		var sql = ins.Eval(parser)
-		var stmt, err = tran.Prepare(sql)
-		defer stmt.Close()
-		if err != nil {
-			return err
-		}
 		var l = len(names)
 		var args = make([]interface{}, 0, l)
 		// 因为要填充，无论如何这里也要传入一个指针，不是指针的请自觉panic……
 		var val = reflect.ValueOf(obj).Elem()
+		tran.db.touch(m, val, true)
 		for _, name := range names {
-			var field, _ = typ.FieldByName(name)
-			var arg interface{} = ExtractField(val.FieldByName(name), field)
+			var arg interface{} = m.arg(val, name)
 			args = append(args, arg)
 		}
-		rset, err := stmt.Query(args...)
-		defer rset.Close()
+		rset, err := tran.db.query(tran.Tx, "InsertMerge", typ, sql, args, names)
 		if err != nil {
 			return err
 		}
+		defer rset.Close()
 		if rset.Next() {
-			m.returning(rset, obj)
+			if err := m.returning(rset, obj); err != nil {
+				return err
+			}
 		}
-		return nil
+		return afterInsert(tran.db.Context(), obj, tran.Tx)
 	} else {
 		var message = fmt.Sprintf("%s is't a regiested type",
 			fullGoName(typ))
@@ -563,10 +933,23 @@ func (tran *Tran) InsertMerge(obj interface{}) error {
 type Query struct {
 	*sql.Stmt
 	table *DbTable
+	// 以下用于拦截器链
+	sql  string
+	db   *Engine
+	tran bool
+}
+
+// query 通过 Engine 的拦截器链执行预备好的语句
+func (q *Query) query(op string, args []interface{}, names []string) (*sql.Rows, error) {
+	var call = q.db.newCall(nil, KindQuery, op, *q.table.gotype, q.sql, args, names)
+	call.stmt = q.Stmt
+	call.InTran = q.tran
+	var err = q.db.invoke(call)
+	return call.Rows, err
 }
 
 func (q *Query) Q(args ...interface{}) (*ResultSet, error) {
-	var rows, err = q.Query(args...)
+	var rows, err = q.query("Query.Q", args, nil)
 	if err == nil {
 		return &ResultSet{rows, q.table}, nil
 	} else {
@@ -581,14 +964,16 @@ func (q *Query) QBy(arg interface{}) (*ResultSet, error) {
 	var val = reflect.ValueOf(arg)
 	var typ = val.Type()
 	var args = make([]interface{}, 0, val.NumField())
+	var names = make([]string, 0, val.NumField())
 	for i := 0; i < val.NumField(); i++ {
 		var field = val.Field(i)
 		if field.CanSet() {
 			var _arg = ExtractField(field, typ.Field(i))
 			args = append(args, &_arg)
+			names = append(names, typ.Field(i).Name)
 		}
 	}
-	var rows, err = q.Query(args...)
+	var rows, err = q.query("Query.QBy", args, names)
 	if err == nil {
 		return &ResultSet{rows, q.table}, nil
 	} else {
@@ -604,7 +989,7 @@ type ResultSet struct {
 //Scan a row and fetch into the object
 //严格来说，这里传入的对象应该严格匹配prepare时使用的类型，
 //但是从理论来讲，似乎任何结构相同的都可以。有待测试
-//此处返回值应为error，但是fetcher构造的时候没有加入，这个将来应该补全
+//FetchOne 和 LoadOne 不返回错误，需要检查错误的话请用 Load
 func (r *ResultSet) FetchOne(obj interface{}) {
 	r.table.returning(r.Rows, obj)
 }
@@ -612,6 +997,14 @@ func (r *ResultSet) LoadOne(obj interface{}) {
 	r.table.all(r.Rows, obj)
 }
 
+// Load 跟 LoadOne 一样按字段名把当前行加载到 obj ，返回 Scan 和 JSON 解码的错误
+func (r *ResultSet) Load(obj interface{}) error {
+	if r.table == nil {
+		return errors.New("result set has no registered type to load")
+	}
+	return r.table.all(r.Rows, obj)
+}
+
 // get the first column in current row, like scalar method
 // in .net clr's ado.net
 // this method don't close connect, need close it after used.
@@ -2,6 +2,8 @@ package pgears
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 )
 
 type NotFound struct{
@@ -22,3 +24,25 @@ func NewNotFound(object interface{}) NotFound{
 func (e NotFound)Error()string{
 	return e.message
 }
+
+// StaleObject 表示带 version 字段的对象在读取之后已经被别人修改或删除了，
+// Update 和 Delete 因为版本号不匹配而没有影响任何行
+type StaleObject struct {
+	message string
+}
+
+// NewStaleObject 的消息里只有类型名和主键，不把整个对象格式化进去，免得把敏感的字段带到日志里
+func NewStaleObject(m *DbTable, val reflect.Value) StaleObject {
+	var keys = m.Pk.GoKeys()
+	var pks = make([]string, 0, len(keys))
+	for _, key := range keys {
+		pks = append(pks, fmt.Sprintf("%s=%v", key, m.arg(val, key)))
+	}
+	var message = fmt.Sprintf("%s(%s) is stale, it has been modified or deleted",
+		fullGoName(*m.gotype), strings.Join(pks, ", "))
+	return StaleObject{message}
+}
+
+func (e StaleObject) Error() string {
+	return e.message
+}
@@ -0,0 +1,157 @@
+// aggregate.go 提供以 Agg 类型为核心的聚合函数和窗口函数
+package exp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+// Agg 是一个聚合函数或者窗口函数调用，除了参数，还可以带上 DISTINCT 、函数内的
+// ORDER BY 、 FILTER (WHERE ...) 以及 OVER 子句
+type Agg struct {
+	name     string
+	args     []Exp
+	distinct bool
+	orderby  []Exp
+	filter   Exp
+	over     *Win
+	overName string
+}
+
+// Aggregate 构造任意名字的聚合函数，常用的几个见下面的 Sum 、 Avg 等函数
+func Aggregate(name string, args ...Exp) *Agg {
+	return &Agg{name: name, args: args}
+}
+
+// Distinct 生成 agg(DISTINCT x) 的形式
+func (agg *Agg) Distinct() *Agg {
+	agg.distinct = true
+	return agg
+}
+
+// OrderBy 指定聚合函数内部的排序，例如 string_agg(x, ',' ORDER BY y)
+func (agg *Agg) OrderBy(exps ...Exp) *Agg {
+	agg.orderby = append(agg.orderby, exps...)
+	return agg
+}
+
+// Filter 生成 FILTER (WHERE cond)
+func (agg *Agg) Filter(cond Exp) *Agg {
+	agg.filter = cond
+	return agg
+}
+
+// Over 把聚合函数变成窗口函数，传入 nil 的话生成 OVER ()
+func (agg *Agg) Over(w *Win) *Agg {
+	if w == nil {
+		w = Window()
+	}
+	agg.over = w
+	agg.overName = ""
+	return agg
+}
+
+// OverName 引用 Sel.Window 中定义的命名窗口，生成 OVER name
+func (agg *Agg) OverName(name string) *Agg {
+	agg.overName = name
+	agg.over = nil
+	return agg
+}
+
+func (agg *Agg) Eval(env Env) string {
+	var args = evalJoin(env, agg.args, ", ")
+	if agg.distinct {
+		args = "DISTINCT " + args
+	}
+	if agg.orderby != nil {
+		args += " ORDER BY " + evalJoin(env, agg.orderby, ", ")
+	}
+	var command = fmt.Sprintf("%s(%s)", agg.dialectName(), args)
+	if agg.filter != nil {
+		command += fmt.Sprintf(" FILTER (WHERE %s)", agg.filter.Eval(env))
+	}
+	if agg.overName != "" {
+		command += " OVER " + agg.overName
+	} else if agg.over != nil {
+		command += fmt.Sprintf(" OVER (%s)", agg.over.Eval(env))
+	}
+	return command
+}
+
+// SQLite 没有 Postgres 的 json_agg 、 string_agg 和 array_agg ，这里换成功能相同的函数，
+// SQLite 没有数组类型， array_agg 也用 json_group_array ，得到的是 JSON 数组
+func (agg *Agg) dialectName() string {
+	if dbdriver.Sqltype != dbdriver.DB_SQLITE {
+		return agg.name
+	}
+	switch strings.ToLower(agg.name) {
+	case "json_agg", "array_agg":
+		return "json_group_array"
+	case "string_agg":
+		return "group_concat"
+	}
+	return agg.name
+}
+
+// TODO: postgresql 是不是允许count多个字段列啊……
+func Count(fields ...Exp) *Agg {
+	return Aggregate("count", fields...)
+}
+
+// count(*)
+func Counts() *Agg {
+	return Aggregate("count", X())
+}
+
+func Sum(field Exp) *Agg {
+	return Aggregate("sum", field)
+}
+
+func Avg(field Exp) *Agg {
+	return Aggregate("avg", field)
+}
+
+func Min(field Exp) *Agg {
+	return Aggregate("min", field)
+}
+
+func Max(field Exp) *Agg {
+	return Aggregate("max", field)
+}
+
+// ArrayAgg 生成 array_agg(field)，在 SQLite 下是 json_group_array(field)
+func ArrayAgg(field Exp) *Agg {
+	return Aggregate("array_agg", field)
+}
+
+// StringAgg 生成 string_agg(field, sep)，在 SQLite 下是 group_concat(field, sep)
+func StringAgg(field Exp, sep Exp) *Agg {
+	return Aggregate("string_agg", field, sep)
+}
+
+// JsonAgg 生成 json_agg(field)，在 SQLite 下是 json_group_array(field)
+func JsonAgg(field Exp) *Agg {
+	return Aggregate("json_agg", field)
+}
+
+// 以下是窗口函数，使用的时候记得 Over
+
+func RowNumber() *Agg {
+	return Aggregate("row_number")
+}
+
+func Rank() *Agg {
+	return Aggregate("rank")
+}
+
+// Lag 的 args 依次是 offset 和 default ，都可以省略
+func Lag(field Exp, args ...Exp) *Agg {
+	return Aggregate("lag", append([]Exp{field}, args...)...)
+}
+
+// Lead 的 args 依次是 offset 和 default ，都可以省略
+func Lead(field Exp, args ...Exp) *Agg {
+	return Aggregate("lead", append([]Exp{field}, args...)...)
+}
@@ -1,27 +1,82 @@
 // 
 package exp
 
+import (
+	"fmt"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
 type Del struct {
-	from *Table
-	where Exp
+	from      *Table
+	using     []*Table
+	where     Exp
+	orderby   []Exp
+	limit     *int
+	returning []Exp
 }
 func Delete(table *Table) *Del{
-	return &Del{table, nil}
+	return &Del{from: table}
+}
+
+// Using 生成 PostgreSQL 的 DELETE ... USING a, b 。SQLite 没有 USING ，会改写成
+// WHERE EXISTS (SELECT 1 FROM a, b WHERE ...) 的形式，效果是一样的
+func (del *Del) Using(tables ...*Table) *Del {
+	del.using = append(del.using, tables...)
+	return del
 }
 func (del *Del)Where(where Exp) *Del{
 	del.where = where
 	return del
 }
+
+// OrderBy 和 Limit 的实现方式同 Upd ，也不能和 Using 一起用
+func (del *Del) OrderBy(fields ...Exp) *Del {
+	del.orderby = append(del.orderby, fields...)
+	return del
+}
+func (del *Del) Limit(limit int) *Del {
+	del.limit = &limit
+	return del
+}
+func (del *Del) Returning(fields ...Exp) *Del {
+	del.returning = append(del.returning, fields...)
+	return del
+}
 func (del *Del)Eval(env Env)string{
 	var scope = env.Scope()
 	env.SetScope(del)
 	defer env.SetScope(scope)
 	var sql = "DELETE FROM "
 	sql += del.from.Eval(env)
-	// 虽然允许生成无where的delete但是还请慎重的使用这样的语句呀
-	if del.where != nil {
+	if del.using != nil {
+		if del.orderby != nil || del.limit != nil {
+			panic("delete with using can not be ordered or limited")
+		}
+		env.SetScope(qualifiedScope{})
+		if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+			sql += fmt.Sprintf(" WHERE EXISTS (SELECT 1 FROM %s", evalTables(env, del.using))
+			if del.where != nil {
+				sql += " WHERE " + del.where.Eval(env)
+			}
+			sql += ")"
+			// 改写以后 USING 的表只出现在子查询里， RETURNING 只能用本表的字段
+			env.SetScope(del)
+		} else {
+			sql += " USING " + evalTables(env, del.using)
+			if del.where != nil {
+				sql += " WHERE " + del.where.Eval(env)
+			}
+		}
+	} else if del.orderby != nil || del.limit != nil {
+		sql += " WHERE " + limitedRows(env, del.from, del.where, del.orderby, del.limit)
+	} else if del.where != nil {
+		// 虽然允许生成无where的delete但是还请慎重的使用这样的语句呀
 		sql += " WHERE "
 		sql += del.where.Eval(env)
 	}
+	if del.returning != nil {
+		sql += " RETURNING " + evalJoin(env, del.returning, ", ")
+	}
 	return sql
 }
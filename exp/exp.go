@@ -211,6 +211,11 @@ func Arg(order int) *arg {
 	return &arg{order}
 }
 func (a arg) Eval(env Env) string {
+	if r, ok := env.(*renumbered); ok {
+		if order, ok := r.orders[a.Order]; ok {
+			return fmt.Sprintf("$%d", order)
+		}
+	}
 	return fmt.Sprintf("$%d", a.Order)
 }
 
@@ -218,30 +223,144 @@ func (a arg) Eval(env Env) string {
 // 需要调整表达式中参数的 order，这个就是起这种作用的，其实如果全命名化了也就没这个问题了。
 // 之所以公开是因为 Engine 会用到。
 func IncOrder(e Exp, step int) {
+	eachArg(e, func(a *arg) {
+		a.Order += step
+	})
+}
+
+// MaxOrder 返回表达式中最大的参数序号，没有参数的话返回 0 。拼接多段各自从 $1 开始编号
+// 的表达式时，可以用它算出后一段需要顺延的步长。
+func MaxOrder(e Exp) int {
+	var max = 0
+	eachArg(e, func(a *arg) {
+		if a.Order > max {
+			max = a.Order
+		}
+	})
+	return max
+}
+
+// eachArg 遍历表达式树中所有的参数，不认识的表达式类型（例如 snippet）直接跳过
+func eachArg(e Exp, fn func(*arg)) {
+	var each = func(exps []Exp) {
+		for _, ex := range exps {
+			eachArg(ex, fn)
+		}
+	}
 	switch ex := e.(type) {
 	case *equal:
-		IncOrder((*ex).x, step)
-		IncOrder((*ex).y, step)
+		eachArg(ex.x, fn)
+		eachArg(ex.y, fn)
 	case *notequal:
-		IncOrder((*ex).x, step)
-		IncOrder((*ex).y, step)
+		eachArg(ex.x, fn)
+		eachArg(ex.y, fn)
+	case *like:
+		eachArg(ex.x, fn)
+		eachArg(ex.y, fn)
 	case *and:
-		IncOrder((*ex).x, step)
-		IncOrder((*ex).y, step)
+		eachArg(ex.x, fn)
+		eachArg(ex.y, fn)
 	case *or:
-		IncOrder((*ex).x, step)
-		IncOrder((*ex).y, step)
+		eachArg(ex.x, fn)
+		eachArg(ex.y, fn)
 	case *great:
-		IncOrder((*ex).x, step)
-		IncOrder((*ex).y, step)
+		eachArg(ex.x, fn)
+		eachArg(ex.y, fn)
 	case *less:
-		IncOrder((*ex).x, step)
-		IncOrder((*ex).y, step)
+		eachArg(ex.x, fn)
+		eachArg(ex.y, fn)
+	case *in:
+		eachArg(ex.test, fn)
+		each(ex.set)
 	case *not:
-		IncOrder((*ex).exp, step)
+		eachArg(ex.exp, fn)
+	case *function:
+		each(ex.args)
+	case *binOpt:
+		eachArg(ex.left, fn)
+		eachArg(ex.right, fn)
+	case *fieldIsNull:
+		eachArg(ex.exp, fn)
+	case isNullFunc:
+		eachArg(ex.field, fn)
+		eachArg(ex.exp, fn)
+	case nullif:
+		eachArg(ex.field, fn)
+		eachArg(ex.exp, fn)
+	case *fullTextSearch:
+		eachArg(ex.field, fn)
+		eachArg(ex.query, fn)
+	case *desc:
+		eachArg(ex.field, fn)
+	case *asc:
+		eachArg(ex.field, fn)
+	case *nulls:
+		eachArg(ex.order, fn)
+	case *Agg:
+		each(ex.args)
+		each(ex.orderby)
+		eachArg(ex.filter, fn)
+		if ex.over != nil {
+			eachArg(ex.over, fn)
+		}
+	case *Win:
+		each(ex.partitionby)
+		each(ex.orderby)
+		eachArg(ex.start, fn)
+		eachArg(ex.end, fn)
+	case *namedWindow:
+		eachArg(ex.win, fn)
+	case *Cases:
+		eachArg(ex.subject, fn)
+		each(ex.whens)
+		each(ex.thens)
+		eachArg(ex.other, fn)
+	case *coalesce:
+		each(ex.exps)
+	case *extremum:
+		each(ex.exps)
+	case *cast:
+		eachArg(ex.exp, fn)
+	case *arith:
+		eachArg(ex.left, fn)
+		eachArg(ex.right, fn)
+	case *brackets:
+		eachArg(ex.exp, fn)
+	case *where:
+		eachArg(ex.exp, fn)
+	case *join:
+		eachArg(ex.on, fn)
+	case *leftjoin:
+		eachArg(ex.on, fn)
+	case Sel:
+		ex.eachArg(fn)
+	case *Sel:
+		ex.eachArg(fn)
+	case *Upd:
+		each(ex.set)
+		eachArg(ex.where, fn)
+		each(ex.orderby)
+		each(ex.returning)
+	case *Del:
+		eachArg(ex.where, fn)
+		each(ex.orderby)
+		each(ex.returning)
+	case *Ins:
+		each(ex.values)
+		if ex.sel != nil {
+			eachArg(ex.sel, fn)
+		}
 	case *arg:
-		ex.Order += step
+		fn(ex)
+	}
+}
+
+// rowKey 返回数据库内部的行标识列名，PostgreSQL 是 ctid ，SQLite 是 rowid
+func rowKey() string {
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		return "rowid"
 	}
+	return "ctid"
 }
 
 type function struct {
@@ -303,8 +422,8 @@ type fieldIsNull struct {
 	exp Exp
 }
 
-func FieldIsNull(field Exp) fieldIsNull {
-	return fieldIsNull{field}
+func FieldIsNull(field Exp) Exp {
+	return &fieldIsNull{field}
 }
 func (isnull *fieldIsNull) Eval(env Env) string {
 	return fmt.Sprintf("%s is NULL", isnull.exp.Eval(env))
@@ -315,11 +434,12 @@ type isNullFunc struct {
 	exp   Exp
 }
 
+// IsNullFunc 原本是照着 SQL Server 的 isNull 写的，PostgreSQL 和 SQLite 里对应的都是 coalesce
 func IsNullFunc(field Exp, exp Exp) Exp {
 	return isNullFunc{field, exp}
 }
 func (isnull isNullFunc) Eval(env Env) string {
-	return fmt.Sprintf("isNull(%s, %s)", isnull.field.Eval(env),
+	return fmt.Sprintf("coalesce(%s, %s)", isnull.field.Eval(env),
 		isnull.exp.Eval(env))
 }
 
@@ -375,31 +495,52 @@ func (d desc) Eval(env Env) string {
 	return d.field.Eval(env) + " desc"
 }
 
-type count struct {
-	fields []Exp
-	isAll  bool
+type asc struct {
+	field Exp
+}
+
+// Asc 生成一个用于orderby asc的表达式，默认就是升序，显式写出来主要是为了配合 NullsFirst/NullsLast
+func Asc(field Exp) Exp {
+	return &asc{field}
 }
 
-// TODO: postgresql 是不是允许count多个字段列啊……
-func Count(fields ...Exp) Exp {
-	return &count{fields, false}
+func (a asc) Eval(env Env) string {
+	return a.field.Eval(env) + " asc"
 }
 
-// count(*)
-func Counts() Exp {
-	return &count{[]Exp{}, true}
+// orderTarget 去掉 Asc 、 Desc 、 NullsFirst 、 NullsLast 这些排序修饰，返回被排序的表达式
+func orderTarget(e Exp) Exp {
+	switch ex := e.(type) {
+	case *desc:
+		return orderTarget(ex.field)
+	case *asc:
+		return orderTarget(ex.field)
+	case *nulls:
+		return orderTarget(ex.order)
+	}
+	return e
 }
-func (d count) Eval(env Env) string {
-	if d.isAll {
-		return "count(*)"
-	} else {
-		fs := make([]string, 0, len(d.fields))
-		for _, f := range d.fields {
-			fs = append(fs, f.Eval(env))
-		}
-		fields := strings.Join(fs, ",")
-		return fmt.Sprintf("count(%s)", fields)
+
+type nulls struct {
+	order Exp
+	first bool
+}
+
+// NullsFirst 指定排序时 null 排在前面，order 可以是字段，也可以是 Asc/Desc 的结果
+func NullsFirst(order Exp) Exp {
+	return &nulls{order, true}
+}
+
+// NullsLast 指定排序时 null 排在后面
+func NullsLast(order Exp) Exp {
+	return &nulls{order, false}
+}
+
+func (n nulls) Eval(env Env) string {
+	if n.first {
+		return n.order.Eval(env) + " nulls first"
 	}
+	return n.order.Eval(env) + " nulls last"
 }
 
 type brackets struct {
@@ -1,7 +1,10 @@
 package exp
 
 import (
+	"sort"
 	"strings"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
 )
 
 // Insert 结构体的 Returing 操作会返回到结构参数的对效应字段，而 Insert 一组值则会将得到的结果集返回
@@ -10,10 +13,13 @@ type Ins struct{
 	fields []Exp
 	values []Exp
 	returning []Exp
+	sel *Sel
+	defaults bool
+	overriding string
 }
 
 func Insert(table *Table, fields... Exp) *Ins{
-	return &Ins{table, fields, make([]Exp, 0, len(fields)), nil}
+	return &Ins{into: table, fields: fields, values: make([]Exp, 0, len(fields))}
 }
 func (ins *Ins)Values(args... Exp) *Ins{
 	for _, a := range args{
@@ -21,6 +27,31 @@ func (ins *Ins)Values(args... Exp) *Ins{
 	}
 	return ins
 }
+
+// Select 生成 INSERT INTO t (fields) SELECT ... ，这时不再生成 values
+func (ins *Ins) Select(sel *Sel) *Ins {
+	ins.sel = sel
+	return ins
+}
+
+// DefaultValues 生成 INSERT INTO t DEFAULT VALUES ，所有字段都取表定义里的默认值
+func (ins *Ins) DefaultValues() *Ins {
+	ins.defaults = true
+	return ins
+}
+
+// OverridingSystemValue 允许给 GENERATED ALWAYS AS IDENTITY 的字段显式赋值，
+// SQLite 没有 identity 字段，会忽略这个选项
+func (ins *Ins) OverridingSystemValue() *Ins {
+	ins.overriding = "SYSTEM"
+	return ins
+}
+
+// OverridingUserValue 让 identity 字段忽略传入的值，仍然由数据库生成
+func (ins *Ins) OverridingUserValue() *Ins {
+	ins.overriding = "USER"
+	return ins
+}
 func (ins *Ins)Returning(fields... Exp) *Ins{
 	if ins.returning == nil{
 		ins.returning = fields
@@ -37,25 +68,39 @@ func (ins *Ins)Eval(env Env) string{
 	defer env.SetScope(scope)
 	var sql = "INSERT INTO "
 	sql += ins.into.Eval(env)
-	sql += "("
-	var fields = make([]string, 0, len(ins.fields))
-	for _, f := range ins.fields {
-		var field = f.Eval(env)
-		fields = append(fields, field)
+	var fields, values = ins.fields, ins.values
+	if ins.sel == nil && len(values) == 0 {
+		values = make([]Exp, 0, len(fields))
+		for i:=0;i<len(fields);i++{
+			values = append(values, Arg(i+1))
+		}
 	}
-	sql += strings.Join(fields, ", ")
-	sql += ") values("
-	if len(ins.values)==0 {
-		for i:=0;i<len(ins.fields);i++{
-			ins.values = append(ins.values, Arg(i+1))
+	var valuesEnv = env
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE && ins.sel == nil {
+		// SQLite 的 values 里不能写 DEFAULT ，只能把这些字段从插入列表里去掉
+		var orders map[int]int
+		fields, values, orders = withoutDefaults(fields, values)
+		if orders != nil {
+			valuesEnv = &renumbered{env, orders}
 		}
 	}
-	var values = make([]string, 0, len(ins.values))
-	for _, v := range ins.values {
-		values = append(values, v.Eval(env))
+	if ins.defaults || (ins.sel == nil && len(fields) == 0) {
+		sql += " DEFAULT VALUES"
+	} else {
+		sql += "("
+		sql += evalJoin(env, fields, ", ")
+		sql += ")"
+		if ins.overriding != "" && dbdriver.Sqltype != dbdriver.DB_SQLITE {
+			sql += " OVERRIDING " + ins.overriding + " VALUE"
+		}
+		if ins.sel != nil {
+			sql += " " + ins.sel.Eval(env)
+		} else {
+			sql += " values("
+			sql += evalJoin(valuesEnv, values, ", ")
+			sql += ")"
+		}
 	}
-	sql += strings.Join(values, ", ")
-	sql += ")"
 	if len(ins.returning) > 0 {
 		var res = make([]string, 0, len(ins.returning))
 		for _, ref := range ins.returning {
@@ -68,3 +113,59 @@ func (ins *Ins)Eval(env Env) string{
 	return sql
 }
 
+// withoutDefaults 去掉值为 Default() 的字段和值。去掉的字段占着的参数序号会空出来，
+// 这时返回把剩下的参数按原来的顺序重新编成连续的 $1 、 $2 ... 的对照表，否则返回 nil
+func withoutDefaults(fields, values []Exp) ([]Exp, []Exp, map[int]int) {
+	var fs = make([]Exp, 0, len(fields))
+	var vs = make([]Exp, 0, len(values))
+	for idx, v := range values {
+		if _, ok := v.(defaultValue); ok {
+			continue
+		}
+		if idx < len(fields) {
+			fs = append(fs, fields[idx])
+		}
+		vs = append(vs, v)
+	}
+	if len(vs) == len(values) {
+		return fs, vs, nil
+	}
+	return fs, vs, denseOrders(vs)
+}
+
+// denseOrders 给出把 exps 中的参数序号压缩成从 1 开始连续的序号的对照表，相对顺序不变
+func denseOrders(exps []Exp) map[int]int {
+	var used = make(map[int]bool)
+	for _, e := range exps {
+		eachArg(e, func(a *arg) {
+			used[a.Order] = true
+		})
+	}
+	var orders = make([]int, 0, len(used))
+	for order := range used {
+		orders = append(orders, order)
+	}
+	sort.Ints(orders)
+	var dense = make(map[int]int, len(orders))
+	for idx, order := range orders {
+		dense[order] = idx + 1
+	}
+	return dense
+}
+
+// renumbered 在求值的时候按 orders 替换参数的序号，表达式本身不变，
+// 同一个 Ins 求值多次、或者参数被别的表达式共用都没有影响
+type renumbered struct {
+	Env
+	orders map[int]int
+}
+
+type defaultValue struct{}
+
+// Default 用在 Insert 的 Values 里，表示这个字段取表定义的默认值
+func Default() Exp {
+	return defaultValue{}
+}
+func (d defaultValue) Eval(env Env) string {
+	return "DEFAULT"
+}
@@ -0,0 +1,31 @@
+package exp
+
+import (
+	"testing"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+func TestInsertDefaultsOnSqlite(t *testing.T) {
+	var sqltype = dbdriver.Sqltype
+	dbdriver.Sqltype = dbdriver.DB_SQLITE
+	defer func() { dbdriver.Sqltype = sqltype }()
+
+	var t1 = TableAs("Account", "acct")
+	var shared = Arg(3)
+	var ins = Insert(t1, t1.Field("id"), t1.Field("created"), t1.Field("name"), t1.Field("nick")).
+		Values(Arg(1), Default(), shared, Coalesce(Arg(4), shared))
+	var expect = "INSERT INTO acct(id, name, nick) values($1, $2, coalesce($3, $2))"
+	for i := 0; i < 2; i++ {
+		if got := ins.Eval(&testEnv{}); got != expect {
+			t.Errorf("eval %d: expect %s\n but got %s", i, expect, got)
+		}
+	}
+	if shared.Order != 3 {
+		t.Errorf("eval should not change the arg, expect $3 but got $%d", shared.Order)
+	}
+	var where = Equal(t1.Field("name"), shared)
+	if got := where.Eval(&testEnv{}); got != "name=$3" {
+		t.Errorf("expect name=$3 but got %s", got)
+	}
+}
@@ -0,0 +1,216 @@
+// scalar.go 提供 CASE、COALESCE、CAST 和算术、字符串拼接等标量表达式，
+// 遇到 SQLite 和 PostgreSQL 写法不同的地方，在 Eval 时根据 dbdriver.Sqltype 选择输出
+package exp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+// Cases 是 CASE 表达式，subject 为空的是 CASE WHEN cond THEN ... 形式，
+// 否则是 CASE subject WHEN value THEN ... 形式
+type Cases struct {
+	subject Exp
+	whens   []Exp
+	thens   []Exp
+	other   Exp
+}
+
+// Case 构造 CASE WHEN cond THEN x ... END
+func Case() *Cases {
+	return &Cases{}
+}
+
+// CaseOf 构造 CASE subject WHEN value THEN x ... END
+func CaseOf(subject Exp) *Cases {
+	return &Cases{subject: subject}
+}
+
+// When 追加一个分支，对于 Case 来说 when 是条件，对于 CaseOf 来说 when 是要比较的值
+func (c *Cases) When(when, then Exp) *Cases {
+	c.whens = append(c.whens, when)
+	c.thens = append(c.thens, then)
+	return c
+}
+
+func (c *Cases) Else(other Exp) *Cases {
+	c.other = other
+	return c
+}
+
+func (c *Cases) Eval(env Env) string {
+	if len(c.whens) == 0 {
+		panic("case expression need at least one when")
+	}
+	var command = "CASE"
+	if c.subject != nil {
+		command += " " + c.subject.Eval(env)
+	}
+	for idx, when := range c.whens {
+		command += fmt.Sprintf(" WHEN %s THEN %s", when.Eval(env), c.thens[idx].Eval(env))
+	}
+	if c.other != nil {
+		command += " ELSE " + c.other.Eval(env)
+	}
+	return command + " END"
+}
+
+type coalesce struct {
+	exps []Exp
+}
+
+// Coalesce 返回第一个非 null 的参数
+func Coalesce(exps ...Exp) Exp {
+	return &coalesce{exps}
+}
+func (c *coalesce) Eval(env Env) string {
+	return fmt.Sprintf("coalesce(%s)", evalJoin(env, c.exps, ", "))
+}
+
+type extremum struct {
+	greatest bool
+	exps     []Exp
+}
+
+// Greatest 生成 greatest(...)，SQLite 下是多参数的 max(...)。
+// 需要注意 PostgreSQL 会忽略 null 参数，而 SQLite 只要有一个 null 结果就是 null
+func Greatest(exps ...Exp) Exp {
+	return &extremum{true, exps}
+}
+
+// Least 生成 least(...)，SQLite 下是多参数的 min(...)，null 的处理同 Greatest
+func Least(exps ...Exp) Exp {
+	return &extremum{false, exps}
+}
+func (e *extremum) Eval(env Env) string {
+	var name string
+	switch {
+	case dbdriver.Sqltype == dbdriver.DB_SQLITE && e.greatest:
+		name = "max"
+	case dbdriver.Sqltype == dbdriver.DB_SQLITE:
+		name = "min"
+	case e.greatest:
+		name = "greatest"
+	default:
+		name = "least"
+	}
+	return fmt.Sprintf("%s(%s)", name, evalJoin(env, e.exps, ", "))
+}
+
+type cast struct {
+	exp   Exp
+	typ   string
+	colon bool
+}
+
+// Cast 生成 CAST(x AS typ)，typ 按 PostgreSQL 的类型名写，SQLite 下会换成对应的存储类型
+func Cast(x Exp, typ string) Exp {
+	return &cast{x, typ, false}
+}
+
+// PgCast 生成 PostgreSQL 的 x::typ 写法，SQLite 不支持这种写法，会生成跟 Cast 一样的结果
+func PgCast(x Exp, typ string) Exp {
+	return &cast{x, typ, true}
+}
+func (c *cast) Eval(env Env) string {
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		return fmt.Sprintf("CAST(%s AS %s)", c.exp.Eval(env), dbdriver.SqliteCastType(c.typ))
+	}
+	if c.colon {
+		var x = c.exp.Eval(env)
+		// :: 的优先级很高，除了字段、参数、函数这种原子的表达式，都要加括号
+		switch c.exp.(type) {
+		case *Field, *arg, *text, *integer, *function, *Agg, *coalesce, *extremum, *cast, *Cases, *brackets:
+		default:
+			x = "(" + x + ")"
+		}
+		return fmt.Sprintf("%s::%s", x, c.typ)
+	}
+	return fmt.Sprintf("CAST(%s AS %s)", c.exp.Eval(env), c.typ)
+}
+
+// arith 是算术运算和字符串拼接，Eval 时根据优先级决定子表达式要不要加括号
+type arith struct {
+	opt         string
+	left, right Exp
+}
+
+func Add(x, y Exp) Exp {
+	return &arith{"+", x, y}
+}
+func Sub(x, y Exp) Exp {
+	return &arith{"-", x, y}
+}
+func Mul(x, y Exp) Exp {
+	return &arith{"*", x, y}
+}
+func Div(x, y Exp) Exp {
+	return &arith{"/", x, y}
+}
+func Mod(x, y Exp) Exp {
+	return &arith{"%", x, y}
+}
+
+// Concat 生成字符串拼接 a || b || ...，PostgreSQL 和 SQLite 都支持 || 运算符
+func Concat(x, y Exp, more ...Exp) Exp {
+	var ret Exp = &arith{"||", x, y}
+	for _, m := range more {
+		ret = &arith{"||", ret, m}
+	}
+	return ret
+}
+
+func (a *arith) precedence() int {
+	switch a.opt {
+	case "*", "/", "%":
+		return 2
+	case "+", "-":
+		return 1
+	}
+	return 0
+}
+
+func (a *arith) associative() bool {
+	return a.opt == "+" || a.opt == "*" || a.opt == "||"
+}
+
+// || 在 PostgreSQL 里比加减乘除优先级低，在 SQLite 里却是最高的，
+// 所以拼接和算术混用的时候总是加括号
+func (a *arith) operand(env Env, x Exp, right bool) string {
+	var str = x.Eval(env)
+	child, ok := x.(*arith)
+	if !ok {
+		return str
+	}
+	var wrap bool
+	if (a.opt == "||") != (child.opt == "||") {
+		wrap = true
+	} else if child.precedence() < a.precedence() {
+		wrap = true
+	} else if right && child.precedence() == a.precedence() && !(child.opt == a.opt && a.associative()) {
+		// 右边同级的运算只有 a + (b + c) 这种同一个可结合的运算符才能去掉括号，
+		// a - (b - c) 、 a * (b % c) 、 a * (b / c) 都不行
+		wrap = true
+	}
+	if wrap {
+		return "(" + str + ")"
+	}
+	return str
+}
+
+func (a *arith) Eval(env Env) string {
+	return strings.Join([]string{a.operand(env, a.left, false), a.opt,
+		a.operand(env, a.right, true)}, " ")
+}
+
+type now struct{}
+
+// Now 生成数据库端的当前时间，CURRENT_TIMESTAMP 在 PostgreSQL 和 SQLite 中都可以用
+func Now() Exp {
+	return now{}
+}
+func (n now) Eval(env Env) string {
+	return "CURRENT_TIMESTAMP"
+}
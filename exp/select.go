@@ -9,20 +9,33 @@ package exp
 import (
 	"fmt"
 	"strings"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
 )
 
 // desc 应该能作用到确定的排序字段
 type Sel struct {
-	selects  []Exp
-	from     *Table
-	join     []Exp
-	leftjoin []Exp
-	where    Exp
-	groupby  []Exp
-	having   Exp
-	orderby  []Exp
-	limit    *int
-	offset   *int
+	distinct   bool
+	distinctOn []Exp
+	selects    []Exp
+	from       *Table
+	join       []Exp
+	leftjoin   []Exp
+	where      Exp
+	groupby    []Exp
+	having     Exp
+	windows    []Exp
+	orderby    []Exp
+	limit      *int
+	offset     *int
+	sets       []*setOpt
+	locks      []*lock
+}
+
+// setOpt 记录 Sel 之后拼接的 union/intersect/except 分支
+type setOpt struct {
+	name string
+	sel  *Sel
 }
 
 func SelectThem(fields ...string) *Sel {
@@ -54,6 +67,20 @@ func Select(fields ...Exp) *Sel {
 	}
 }
 
+// Distinct 生成 SELECT DISTINCT
+func (sel *Sel) Distinct() *Sel {
+	sel.distinct = true
+	return sel
+}
+
+// DistinctOn 生成 PostgreSQL 的 SELECT DISTINCT ON (exps...)，常用于“每组取最新一行”
+// 这类查询。如果同时指定了 OrderBy ，ORDER BY 开头的几项必须是 DISTINCT ON 的表达式，
+// 否则 Eval 时会 panic 。SQLite 不支持这个语法，Eval 时同样会 panic
+func (sel *Sel) DistinctOn(exps ...Exp) *Sel {
+	sel.distinctOn = append(sel.distinctOn, exps...)
+	return sel
+}
+
 func (sel *Sel) From(t *Table) *Sel {
 	sel.from = t
 	for _, f := range sel.selects {
@@ -98,6 +125,12 @@ func (sel *Sel) Having(exp Exp) *Sel {
 	sel.having = exp
 	return sel
 }
+
+// Window 定义一个命名窗口，生成 WINDOW name AS (...)，聚合函数可以用 OverName 引用它
+func (sel *Sel) Window(name string, w *Win) *Sel {
+	sel.windows = append(sel.windows, &namedWindow{name, w})
+	return sel
+}
 func (sel *Sel) OrderBy(fields ...Exp) *Sel {
 	if sel.orderby == nil {
 		sel.orderby = fields
@@ -118,11 +151,193 @@ func (sel *Sel) Offset(offset int) *Sel {
 	sel.offset = &offset
 	return sel
 }
+
+// ForUpdate 追加一个 FOR UPDATE 锁定子句，接着调用 Of 、 NoWait 或 SkipLocked
+// 可以修饰这个子句，例如 sel.ForUpdate().SkipLocked() 就是任务队列常用的写法
+func (sel *Sel) ForUpdate() *Sel {
+	return sel.lock("UPDATE")
+}
+
+// ForNoKeyUpdate 追加一个 FOR NO KEY UPDATE 锁定子句
+func (sel *Sel) ForNoKeyUpdate() *Sel {
+	return sel.lock("NO KEY UPDATE")
+}
+
+// ForShare 追加一个 FOR SHARE 锁定子句
+func (sel *Sel) ForShare() *Sel {
+	return sel.lock("SHARE")
+}
+
+// ForKeyShare 追加一个 FOR KEY SHARE 锁定子句
+func (sel *Sel) ForKeyShare() *Sel {
+	return sel.lock("KEY SHARE")
+}
+
+// Of 限定最近一个锁定子句只锁给定的表，生成 FOR UPDATE OF a, b
+func (sel *Sel) Of(tables ...*Table) *Sel {
+	var l = sel.lastLock()
+	l.of = append(l.of, tables...)
+	return sel
+}
+
+// NoWait 让最近一个锁定子句在拿不到锁的时候立刻报错
+func (sel *Sel) NoWait() *Sel {
+	sel.lastLock().wait = "NOWAIT"
+	return sel
+}
+
+// SkipLocked 让最近一个锁定子句跳过已经被锁住的行
+func (sel *Sel) SkipLocked() *Sel {
+	sel.lastLock().wait = "SKIP LOCKED"
+	return sel
+}
+
+func (sel *Sel) lock(strength string) *Sel {
+	sel.locks = append(sel.locks, &lock{strength: strength})
+	return sel
+}
+
+func (sel *Sel) lastLock() *lock {
+	if len(sel.locks) == 0 {
+		panic("call ForUpdate, ForNoKeyUpdate, ForShare or ForKeyShare first")
+	}
+	return sel.locks[len(sel.locks)-1]
+}
+
+// Union 把 other 作为一个 UNION 分支拼到当前查询之后，返回的仍然是 sel 本身。
+// 拼接以后 sel 的 OrderBy、Limit 和 Offset 作用于整个复合查询，这跟 SQL 的语义是一致的。
+// other 里的参数按照它自己从 $1 开始编号就可以，这里会把它顺延到 sel 已有的参数之后，
+// 所以一个分支不要同时拼到两个查询里。
+func (sel *Sel) Union(other *Sel) *Sel {
+	return sel.setOpt("UNION", other)
+}
+
+// UnionAll 同 Union ，但是保留重复的行
+func (sel *Sel) UnionAll(other *Sel) *Sel {
+	return sel.setOpt("UNION ALL", other)
+}
+
+// Intersect 拼接一个 INTERSECT 分支，参数编号的规则同 Union
+func (sel *Sel) Intersect(other *Sel) *Sel {
+	return sel.setOpt("INTERSECT", other)
+}
+
+// Except 拼接一个 EXCEPT 分支，参数编号的规则同 Union
+func (sel *Sel) Except(other *Sel) *Sel {
+	return sel.setOpt("EXCEPT", other)
+}
+
+func (sel *Sel) setOpt(name string, other *Sel) *Sel {
+	IncOrder(other, MaxOrder(sel))
+	sel.sets = append(sel.sets, &setOpt{name, other})
+	return sel
+}
+
+// eachArg 遍历查询中的参数，包括拼接进来的分支
+func (sel Sel) eachArg(fn func(*arg)) {
+	var all = make([]Exp, 0)
+	all = append(all, sel.distinctOn...)
+	all = append(all, sel.selects...)
+	all = append(all, sel.join...)
+	all = append(all, sel.leftjoin...)
+	all = append(all, sel.where, sel.having)
+	all = append(all, sel.groupby...)
+	all = append(all, sel.windows...)
+	all = append(all, sel.orderby...)
+	for _, ex := range all {
+		eachArg(ex, fn)
+	}
+	for _, s := range sel.sets {
+		eachArg(s.sel, fn)
+	}
+}
+
 func (sel Sel) Eval(env Env) string {
 	var scope = env.Scope()
 	env.SetScope(sel)
 	defer env.SetScope(scope)
+	var command = sel.body(env)
+	for _, s := range sel.sets {
+		command += fmt.Sprintf(" %s %s", s.name, s.sel.branch(env))
+	}
+	if sel.sets != nil {
+		// 复合查询的 ORDER BY 只能引用结果列，不能带上某个分支的表名
+		env.SetScope(setScope{})
+	}
+	command += sel.tail(env)
+	for _, l := range sel.locks {
+		command += l.Eval(env)
+	}
+	return command
+}
+
+// lock 是 Sel 末尾的 FOR UPDATE/FOR SHARE 子句
+type lock struct {
+	strength string
+	of       []*Table
+	wait     string
+}
+
+// SQLite 没有行锁，写事务本身就会锁住整个库，所以这里直接省略锁定子句。
+// 但是 NOWAIT 和 SKIP LOCKED 的语义没法模拟，只能 panic
+func (l *lock) Eval(env Env) string {
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		if l.wait != "" {
+			panic(l.wait + " is not supported by sqlite")
+		}
+		return ""
+	}
+	var command = " FOR " + l.strength
+	if l.of != nil {
+		var names = make([]string, 0, len(l.of))
+		for _, t := range l.of {
+			if (t.DbName == "") && (t.AliasName == "") {
+				t.Eval(env)
+			}
+			names = append(names, t.Alias())
+		}
+		command += " OF " + strings.Join(names, ", ")
+	}
+	if l.wait != "" {
+		command += " " + l.wait
+	}
+	return command
+}
+
+// setScope 是复合查询尾部的作用域，Field 在这里只输出列名
+type setScope struct{}
+
+func (s setScope) Eval(env Env) string {
+	return ""
+}
+
+// branch 生成作为复合查询分支的 SQL ，分支自带排序、分页或者嵌套的复合查询时要加括号，
+// SQLite 不认括号包围的分支，只能包成子查询
+func (sel *Sel) branch(env Env) string {
+	var command = sel.Eval(env)
+	if sel.orderby == nil && sel.limit == nil && sel.offset == nil && sel.sets == nil {
+		return command
+	}
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		return fmt.Sprintf("SELECT * FROM (%s)", command)
+	}
+	return fmt.Sprintf("(%s)", command)
+}
+
+// body 生成 select 到 having 的部分
+func (sel Sel) body(env Env) string {
 	var command = "SELECT "
+	if sel.distinctOn != nil {
+		if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+			panic("distinct on is not supported by sqlite")
+		}
+		if sel.sets == nil {
+			sel.checkDistinctOn(env)
+		}
+		command += fmt.Sprintf("DISTINCT ON (%s) ", evalJoin(env, sel.distinctOn, ", "))
+	} else if sel.distinct {
+		command += "DISTINCT "
+	}
 	if sel.selects != nil {
 		var fields = make([]string, 0)
 		for _, f := range sel.selects {
@@ -157,6 +372,35 @@ func (sel Sel) Eval(env Env) string {
 	if sel.having != nil {
 		command += " HAVING " + sel.having.Eval(env)
 	}
+	if sel.windows != nil {
+		command += " WINDOW " + evalJoin(env, sel.windows, ", ")
+	}
+	return command
+}
+
+// checkDistinctOn 检查 ORDER BY 开头的各项都是 DISTINCT ON 的表达式，直到 DISTINCT ON
+// 的表达式全部出现过为止，这跟 PostgreSQL 的规则一致。比较的是求值以后的 SQL 文本
+func (sel Sel) checkDistinctOn(env Env) {
+	var on = make(map[string]bool)
+	for _, e := range sel.distinctOn {
+		on[e.Eval(env)] = true
+	}
+	var matched = make(map[string]bool)
+	for _, o := range sel.orderby {
+		if len(matched) == len(on) {
+			return
+		}
+		var key = orderTarget(o).Eval(env)
+		if !on[key] {
+			panic(fmt.Sprintf("SELECT DISTINCT ON expressions must match initial ORDER BY expressions, got %s", key))
+		}
+		matched[key] = true
+	}
+}
+
+// tail 生成 order by 、 limit 和 offset 部分
+func (sel Sel) tail(env Env) string {
+	var command = ""
 	if sel.orderby != nil {
 		var orderby = make([]string, 0)
 		for _, o := range sel.orderby {
@@ -80,9 +80,18 @@ func (f *Field)Eval(env Env)string{
 		f.DbName = env.FinaToCona(f.Table.GoName, f.GoName)
 	}
 	var scope = env.Scope()
-	if _, ok := scope.(Sel); ok {
+	switch scope.(type) {
+	case Sel, qualifiedScope:
 		return fmt.Sprintf("%s.%s", f.Table.Alias(), f.DbName)
-	} else {
+	default:
 		return f.DbName
 	}
 }
+
+// qualifiedScope 用于 UPDATE ... FROM 和 DELETE ... USING 的条件部分，这时涉及多个表，
+// 字段要跟 Sel 里一样带上表名
+type qualifiedScope struct{}
+
+func (q qualifiedScope) Eval(env Env) string {
+	return ""
+}
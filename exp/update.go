@@ -6,13 +6,17 @@ import (
 )
 
 type Upd struct {
-	tabl  *Table
-	set   []Exp
-	where Exp
+	tabl      *Table
+	set       []Exp
+	from      []*Table
+	where     Exp
+	orderby   []Exp
+	limit     *int
+	returning []Exp
 }
 
 func Update(t *Table) *Upd {
-	return &Upd{t, nil, nil}
+	return &Upd{tabl: t}
 }
 
 // 一开始我想用map，但是想起来现在参数是按顺序传递的，如果用字典会有问题
@@ -21,28 +25,106 @@ func (upd *Upd) Set(set ...Exp) *Upd {
 	upd.set = set
 	return upd
 }
+
+// From 生成 UPDATE ... FROM a, b ，这时 Where 和 Returning 里的字段都会带上表名
+func (upd *Upd) From(tables ...*Table) *Upd {
+	upd.from = append(upd.from, tables...)
+	return upd
+}
 func (upd *Upd) Where(exp Exp) *Upd {
 	upd.where = exp
 	return upd
 }
+
+// OrderBy 和 Limit 在 PostgreSQL 和 SQLite 里都不是 UPDATE 的标准语法，这里用
+// WHERE ctid/rowid IN (SELECT ... ORDER BY ... LIMIT n) 模拟，所以不能和 From 一起用
+func (upd *Upd) OrderBy(fields ...Exp) *Upd {
+	upd.orderby = append(upd.orderby, fields...)
+	return upd
+}
+func (upd *Upd) Limit(limit int) *Upd {
+	upd.limit = &limit
+	return upd
+}
+func (upd *Upd) Returning(fields ...Exp) *Upd {
+	upd.returning = append(upd.returning, fields...)
+	return upd
+}
 func (upd *Upd) Eval(env Env) string {
 	var scope = env.Scope()
 	env.SetScope(upd)
 	defer env.SetScope(scope)
 	var command = fmt.Sprintf("UPDATE %s ", upd.tabl.Eval(env))
+	// 先生成 FROM 的表，SET 右边的值要在多表的作用域里求值
+	var from string
+	if upd.from != nil {
+		from = evalTables(env, upd.from)
+	}
 	// 虽说要check nil但是如果没有set你update个啥啊……
 	// TODO: 此处应当有 panic
 	if upd.set != nil {
 		command += "SET "
 		var sets = make([]string, 0, len(upd.set))
 		for _, s := range upd.set {
-			sets = append(sets, s.Eval(env))
+			sets = append(sets, upd.evalSet(env, s))
 		}
 		command += strings.Join(sets, ", ")
 	}
-	if upd.where != nil {
+	if upd.from != nil {
+		command += " FROM " + from
+		env.SetScope(qualifiedScope{})
+	}
+	if upd.orderby != nil || upd.limit != nil {
+		if upd.from != nil {
+			panic("update with from can not be ordered or limited")
+		}
+		command += " WHERE " + limitedRows(env, upd.tabl, upd.where, upd.orderby, upd.limit)
+	} else if upd.where != nil {
 		command += " WHERE "
 		command += upd.where.Eval(env)
 	}
+	if upd.returning != nil {
+		command += " RETURNING " + evalJoin(env, upd.returning, ", ")
+	}
 	return command
 }
+
+// evalSet 生成 SET 中的一项。有 FROM 的时候，被赋值的字段不能带表名，右边的值却要带上，
+// 否则两个表有同名字段时会有歧义，甚至取到另一个表的字段
+func (upd *Upd) evalSet(env Env, set Exp) string {
+	var eq, ok = set.(*equal)
+	if !ok || upd.from == nil {
+		return set.Eval(env)
+	}
+	var target = eq.x.Eval(env)
+	env.SetScope(qualifiedScope{})
+	var value = eq.y.Eval(env)
+	env.SetScope(upd)
+	return fmt.Sprintf("%s=%s", target, value)
+}
+
+// evalTables 生成 FROM 或 USING 后面的表列表
+func evalTables(env Env, tables []*Table) string {
+	var names = make([]string, 0, len(tables))
+	for _, t := range tables {
+		names = append(names, t.Eval(env))
+	}
+	return strings.Join(names, ", ")
+}
+
+// limitedRows 生成 ctid IN (SELECT ctid FROM t WHERE ... ORDER BY ... LIMIT n) ，
+// 用来模拟 UPDATE 和 DELETE 的 ORDER BY/LIMIT ，SQLite 下用 rowid 代替 ctid
+func limitedRows(env Env, t *Table, where Exp, orderby []Exp, limit *int) string {
+	var key = rowKey()
+	var sel = Select(Snippet(key)).From(t)
+	if where != nil {
+		sel.Where(where)
+	}
+	if orderby != nil {
+		sel.OrderBy(orderby...)
+	}
+	if limit != nil {
+		sel.Limit(*limit)
+	}
+	return fmt.Sprintf("%s IN (%s)", key, sel.Eval(env))
+}
@@ -0,0 +1,50 @@
+package exp
+
+import "testing"
+
+// testEnv 是测试用的 Env ，类型名和字段名原样作为表名和字段名
+type testEnv struct {
+	scope Exp
+}
+
+func (env *testEnv) TynaToTana(typename string) string {
+	return typename
+}
+
+func (env *testEnv) FinaToCona(typename string, fieldname string) string {
+	return fieldname
+}
+
+func (env *testEnv) Scope() Exp {
+	return env.scope
+}
+
+func (env *testEnv) SetScope(scope Exp) {
+	env.scope = scope
+}
+
+func TestUpdateFrom(t *testing.T) {
+	var acct = TableAs("Account", "acct")
+	var ord = TableAs("Order", "ord")
+	var upd = Update(acct).
+		Set(Equal(acct.Field("status"), ord.Field("status")),
+			Equal(acct.Field("balance"), Add(acct.Field("balance"), ord.Field("amount"))),
+			Equal(acct.Field("note"), Arg(1))).
+		From(ord).
+		Where(Equal(acct.Field("id"), ord.Field("account_id"))).
+		Returning(acct.Field("id"))
+	var expect = "UPDATE acct SET status=ord.status, balance=acct.balance + ord.amount, note=$1 " +
+		"FROM ord WHERE acct.id=ord.account_id RETURNING acct.id"
+	if got := upd.Eval(&testEnv{}); got != expect {
+		t.Errorf("expect %s\n but got %s", expect, got)
+	}
+}
+
+func TestUpdateWithoutFrom(t *testing.T) {
+	var acct = TableAs("Account", "acct")
+	var upd = Update(acct).Set(Equal(acct.Field("status"), Arg(1))).Where(Equal(acct.Field("id"), Arg(2)))
+	var expect = "UPDATE acct SET status=$1 WHERE id=$2"
+	if got := upd.Eval(&testEnv{}); got != expect {
+		t.Errorf("expect %s\n but got %s", expect, got)
+	}
+}
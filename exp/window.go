@@ -0,0 +1,122 @@
+// window.go 提供窗口定义的 Win 类型，它可以用在聚合函数的 OVER 里，也可以通过
+// Sel.Window 定义成命名窗口
+package exp
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Win 描述 OVER (...) 或者 WINDOW w AS (...) 括号里的内容
+type Win struct {
+	base        string
+	partitionby []Exp
+	orderby     []Exp
+	frame       string
+	start       Exp
+	end         Exp
+}
+
+// Window 构造一个空的窗口定义，然后链式的补上分区、排序和框架
+func Window() *Win {
+	return &Win{}
+}
+
+// Base 指定这个窗口在哪个命名窗口的基础上扩展，即 OVER (w ORDER BY ...) 这种写法
+func (w *Win) Base(name string) *Win {
+	w.base = name
+	return w
+}
+
+func (w *Win) PartitionBy(exps ...Exp) *Win {
+	w.partitionby = append(w.partitionby, exps...)
+	return w
+}
+
+func (w *Win) OrderBy(exps ...Exp) *Win {
+	w.orderby = append(w.orderby, exps...)
+	return w
+}
+
+// Rows 指定 ROWS 框架，end 为 nil 的话只生成起点，即 ROWS start 。 start 不能为 nil
+func (w *Win) Rows(start, end Exp) *Win {
+	return w.setFrame("ROWS", start, end)
+}
+
+// Range 指定 RANGE 框架，规则同 Rows
+func (w *Win) Range(start, end Exp) *Win {
+	return w.setFrame("RANGE", start, end)
+}
+
+func (w *Win) setFrame(frame string, start, end Exp) *Win {
+	if start == nil {
+		panic(frame + " frame need a start bound")
+	}
+	w.frame = frame
+	w.start = start
+	w.end = end
+	return w
+}
+
+func (w *Win) Eval(env Env) string {
+	var parts = make([]string, 0, 4)
+	if w.base != "" {
+		parts = append(parts, w.base)
+	}
+	if w.partitionby != nil {
+		parts = append(parts, "PARTITION BY "+evalJoin(env, w.partitionby, ", "))
+	}
+	if w.orderby != nil {
+		parts = append(parts, "ORDER BY "+evalJoin(env, w.orderby, ", "))
+	}
+	if w.frame != "" {
+		if w.end == nil {
+			parts = append(parts, fmt.Sprintf("%s %s", w.frame, w.start.Eval(env)))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s BETWEEN %s AND %s", w.frame,
+				w.start.Eval(env), w.end.Eval(env)))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// 以下是窗口框架的边界
+
+func UnboundedPreceding() Exp {
+	return Snippet("UNBOUNDED PRECEDING")
+}
+
+func UnboundedFollowing() Exp {
+	return Snippet("UNBOUNDED FOLLOWING")
+}
+
+func CurrentRow() Exp {
+	return Snippet("CURRENT ROW")
+}
+
+func Preceding(n int) Exp {
+	return Snippet(fmt.Sprintf("%d PRECEDING", n))
+}
+
+func Following(n int) Exp {
+	return Snippet(fmt.Sprintf("%d FOLLOWING", n))
+}
+
+// namedWindow 是 Sel 中 WINDOW name AS (...) 子句的一项
+type namedWindow struct {
+	name string
+	win  *Win
+}
+
+func (nw *namedWindow) Eval(env Env) string {
+	return fmt.Sprintf("%s AS (%s)", nw.name, nw.win.Eval(env))
+}
+
+// evalJoin 把一组表达式求值以后用 sep 连起来
+func evalJoin(env Env, exps []Exp, sep string) string {
+	var strs = make([]string, 0, len(exps))
+	for _, e := range exps {
+		strs = append(strs, e.Eval(env))
+	}
+	return strings.Join(strs, sep)
+}
@@ -0,0 +1,102 @@
+// hooks.go 定义了映射结构可以选择实现的生命周期钩子。Engine 和 Tran 的增删改查方法会在
+// 对应的时机调用它们，钩子返回的错误会中止操作，在 AutoTran 里还会导致事务回滚。
+// 钩子拿到的 ctx 是发起操作的 Engine 的 context ，见 Engine.WithContext 。
+package pgears
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Executor 是钩子访问数据库的接口，Engine 调用钩子时传入的是连接池，Tran 调用时传入的是
+// 事务本身，所以在钩子里做的查询和修改跟主操作在同一个事务中
+type Executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// BeforeInserter 在 Insert 和 InsertMerge 生成 SQL 之前调用，可以用来校验或者计算派生字段
+type BeforeInserter interface {
+	BeforeInsert(ctx context.Context, exec Executor) error
+}
+
+// AfterInserter 在插入成功以后调用，InsertMerge 的 dbgen 字段这时已经加载回来了
+type AfterInserter interface {
+	AfterInsert(ctx context.Context, exec Executor) error
+}
+
+// BeforeUpdater 在 Update 、 UpdateFields 和 UpdateReturning 之前调用
+type BeforeUpdater interface {
+	BeforeUpdate(ctx context.Context, exec Executor) error
+}
+
+// AfterUpdater 在更新成功以后调用
+type AfterUpdater interface {
+	AfterUpdate(ctx context.Context, exec Executor) error
+}
+
+// BeforeDeleter 在 Delete 、 HardDelete 和 DeleteReturning 之前调用，软删除也一样
+type BeforeDeleter interface {
+	BeforeDelete(ctx context.Context, exec Executor) error
+}
+
+// AfterDeleter 在删除成功以后调用
+type AfterDeleter interface {
+	AfterDelete(ctx context.Context, exec Executor) error
+}
+
+// AfterFetcher 在 Fetch 和 FetchForUpdate 加载数据以后调用
+type AfterFetcher interface {
+	AfterFetch(ctx context.Context, exec Executor) error
+}
+
+func beforeInsert(ctx context.Context, obj interface{}, exec Executor) error {
+	if hook, ok := obj.(BeforeInserter); ok {
+		return hook.BeforeInsert(ctx, exec)
+	}
+	return nil
+}
+
+func afterInsert(ctx context.Context, obj interface{}, exec Executor) error {
+	if hook, ok := obj.(AfterInserter); ok {
+		return hook.AfterInsert(ctx, exec)
+	}
+	return nil
+}
+
+func beforeUpdate(ctx context.Context, obj interface{}, exec Executor) error {
+	if hook, ok := obj.(BeforeUpdater); ok {
+		return hook.BeforeUpdate(ctx, exec)
+	}
+	return nil
+}
+
+func afterUpdate(ctx context.Context, obj interface{}, exec Executor) error {
+	if hook, ok := obj.(AfterUpdater); ok {
+		return hook.AfterUpdate(ctx, exec)
+	}
+	return nil
+}
+
+func beforeDelete(ctx context.Context, obj interface{}, exec Executor) error {
+	if hook, ok := obj.(BeforeDeleter); ok {
+		return hook.BeforeDelete(ctx, exec)
+	}
+	return nil
+}
+
+func afterDelete(ctx context.Context, obj interface{}, exec Executor) error {
+	if hook, ok := obj.(AfterDeleter); ok {
+		return hook.AfterDelete(ctx, exec)
+	}
+	return nil
+}
+
+func afterFetch(ctx context.Context, obj interface{}, exec Executor) error {
+	if hook, ok := obj.(AfterFetcher); ok {
+		return hook.AfterFetch(ctx, exec)
+	}
+	return nil
+}
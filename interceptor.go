@@ -0,0 +1,220 @@
+// interceptor.go 提供 Engine 执行 SQL 的拦截器链。Engine 、 Tran 、 Query 中所有
+// prepare/query/exec 都会经过这里，所以日志、统计、追踪、改写 SQL 或者注入故障都可以
+// 在一个地方统一完成
+package pgears
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"strings"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+// Call 的 Kind 取值
+const (
+	KindPrepare = "prepare"
+	KindQuery   = "query"
+	KindExec    = "exec"
+)
+
+// Call 描述一次经过拦截器链的数据库调用。拦截器可以在调用 next 之前修改 SQL 和 Args ，
+// 在 next 返回以后读取 Stmt 、 Rows 或 Result 。
+// 需要注意的是，对 Query 这种已经 Prepare 好的语句，执行阶段修改 SQL 是没有作用的，
+// 要改写它们请在 KindPrepare 阶段进行
+type Call struct {
+	Context context.Context
+	// Kind 是 KindPrepare 、 KindQuery 或 KindExec
+	Kind string
+	// Op 是发起调用的 pgears 操作，例如 Fetch 、 Insert 、 Query.Q
+	Op   string
+	SQL  string
+	Args []interface{}
+	// Fields 是 Args 中各个参数对应的 Go 结构字段名，不知道的时候为 nil ，见 SafeArgs
+	Fields []string
+	// Type 和 Table 是操作涉及的映射类型和表名，跟注册类型无关的调用为空
+	Type   reflect.Type
+	Table  string
+	InTran bool
+
+	// 以下是调用的结果，根据 Kind 只有一个会有值
+	Stmt   *sql.Stmt
+	Rows   *sql.Rows
+	Result sql.Result
+
+	db   *Engine
+	exec Executor
+	stmt *sql.Stmt
+}
+
+// Redacted 是 SafeArgs 中用来替换敏感参数的值
+const Redacted = "[REDACTED]"
+
+// SafeArgs 返回可以写进日志的参数列表，对应字段带有 sensitive:"true" 标签的参数被替换成
+// Redacted 。参数跟字段的对应关系来自 Fields ，直接传入参数的 Query.Q 、 Scalar 等调用
+// 没有这个信息，它们的参数会原样返回
+func (call *Call) SafeArgs() []interface{} {
+	var table *DbTable
+	if call.Type != nil && call.db != nil {
+		table = call.db.gomap[call.Type]
+	}
+	var args = make([]interface{}, len(call.Args))
+	copy(args, call.Args)
+	if table == nil {
+		return args
+	}
+	for idx, name := range call.Fields {
+		if idx >= len(args) {
+			break
+		}
+		if field, ok := table.Fields.GoGet(name); ok && field.Sensitive {
+			args[idx] = Redacted
+		}
+	}
+	return args
+}
+
+// Handler 执行一次调用，拦截器通过 next 把调用交给链条中的下一环
+type Handler func(call *Call) error
+
+// Interceptor 包装一次调用，不调用 next 的话这次调用就不会真正执行
+type Interceptor func(call *Call, next Handler) error
+
+// Use 在拦截器链的末尾追加拦截器，先加入的在外层。请在开始使用 Engine 之前设置好
+func (e *Engine) Use(interceptors ...Interceptor) {
+	e.interceptors = append(e.interceptors, interceptors...)
+}
+
+// invoke 让 call 依次经过所有拦截器，最后真正执行
+func (e *Engine) invoke(call *Call) error {
+	var handler Handler = perform
+	for i := len(e.interceptors) - 1; i >= 0; i-- {
+		var interceptor, next = e.interceptors[i], handler
+		handler = func(call *Call) error {
+			return interceptor(call, next)
+		}
+	}
+	return handler(call)
+}
+
+// contextExecutor 是可以带上 context 执行的 Executor ， *sql.DB 、 *sql.Tx 、 Engine
+// 和 Tran 都是
+type contextExecutor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// perform 是拦截器链的终点，语句会带上 call.Context 执行
+func perform(call *Call) error {
+	var query = sqliteParams(call.SQL)
+	if call.stmt == nil {
+		if exec, ok := call.exec.(contextExecutor); ok {
+			return performContext(call, exec, query)
+		}
+	}
+	var err error
+	switch call.Kind {
+	case KindPrepare:
+		call.Stmt, err = call.exec.Prepare(query)
+	case KindQuery:
+		if call.stmt != nil {
+			call.Rows, err = call.stmt.QueryContext(call.Context, call.Args...)
+		} else {
+			call.Rows, err = call.exec.Query(query, call.Args...)
+		}
+	case KindExec:
+		if call.stmt != nil {
+			call.Result, err = call.stmt.ExecContext(call.Context, call.Args...)
+		} else {
+			call.Result, err = call.exec.Exec(query, call.Args...)
+		}
+	}
+	return err
+}
+
+// performContext 在支持 context 的 Executor 上执行没有预备好的语句
+func performContext(call *Call, exec contextExecutor, query string) error {
+	var err error
+	switch call.Kind {
+	case KindPrepare:
+		call.Stmt, err = exec.PrepareContext(call.Context, query)
+	case KindQuery:
+		call.Rows, err = exec.QueryContext(call.Context, query, call.Args...)
+	case KindExec:
+		call.Result, err = exec.ExecContext(call.Context, query, call.Args...)
+	}
+	return err
+}
+
+// sqliteParams 在 SQLite 上把 query 里的 $N 换成 ?N 。SQLite 把 $1 、 $2 当成命名参数，
+// 按第一次出现的先后绑定，$2 写在 $1 前面的话参数就错位了，?N 才是按序号绑定的。
+// 引号和 -- 注释里的内容不动，拦截器看到的仍然是原来的 SQL
+func sqliteParams(query string) string {
+	if dbdriver.Sqltype != dbdriver.DB_SQLITE || !strings.Contains(query, "$") {
+		return query
+	}
+	var buf = []byte(query)
+	for i := 0; i < len(buf); i++ {
+		switch c := buf[i]; {
+		case c == '\'' || c == '"' || c == '`':
+			// 引号里的 '' 转义相当于先结束再开始一段，不用特别处理
+			for i++; i < len(buf) && buf[i] != c; i++ {
+			}
+		case c == '-' && i+1 < len(buf) && buf[i+1] == '-':
+			for i += 2; i < len(buf) && buf[i] != '\n'; i++ {
+			}
+		case c == '$' && i+1 < len(buf) && isDigit(buf[i+1]) && (i == 0 || !isWordByte(buf[i-1])):
+			buf[i] = '?'
+		}
+	}
+	return string(buf)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isWordByte(c byte) bool {
+	return isDigit(c) || c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// newCall 构造一个 Call ， typ 为 nil 表示跟注册类型无关
+func (e *Engine) newCall(exec Executor, kind, op string, typ reflect.Type, query string,
+	args []interface{}, names []string) *Call {
+	var call = &Call{Context: e.Context(), Kind: kind, Op: op, SQL: query, Args: args,
+		Fields: names, Type: typ, db: e, exec: exec}
+	if _, ok := exec.(*sql.Tx); ok {
+		call.InTran = true
+	}
+	if typ != nil {
+		if table, ok := e.gomap[typ]; ok {
+			call.Table = table.tablename
+		}
+	}
+	return call
+}
+
+// prepare 通过拦截器链 Prepare 一条语句
+func (e *Engine) prepare(exec Executor, op string, typ reflect.Type, query string) (*sql.Stmt, error) {
+	var call = e.newCall(exec, KindPrepare, op, typ, query, nil, nil)
+	var err = e.invoke(call)
+	return call.Stmt, err
+}
+
+// query 通过拦截器链执行一个查询
+func (e *Engine) query(exec Executor, op string, typ reflect.Type, query string,
+	args []interface{}, names []string) (*sql.Rows, error) {
+	var call = e.newCall(exec, KindQuery, op, typ, query, args, names)
+	var err = e.invoke(call)
+	return call.Rows, err
+}
+
+// exec 通过拦截器链执行一条不返回结果集的语句
+func (e *Engine) exec(exec Executor, op string, typ reflect.Type, query string,
+	args []interface{}, names []string) (sql.Result, error) {
+	var call = e.newCall(exec, KindExec, op, typ, query, args, names)
+	var err = e.invoke(call)
+	return call.Result, err
+}
@@ -0,0 +1,119 @@
+// logger.go 提供基于 log/slog 的 SQL 日志拦截器，用来代替以前散落在 engine.go 里的
+// fmt.Println(sql)
+package pgears
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+// LogOptions 是 QueryLogger 的配置
+type LogOptions struct {
+	// Level 是普通语句的日志级别，零值就是 slog.LevelInfo ，调试时一般用 slog.LevelDebug
+	Level slog.Level
+	// SlowThreshold 大于 0 的时候，耗时不少于它的语句作为慢查询用 Warn 级别记录
+	SlowThreshold time.Duration
+	// Explain 为 true 的时候，慢查询的日志会带上 EXPLAIN 得到的执行计划
+	Explain bool
+}
+
+// QueryLogger 返回一个把每次数据库调用写到 logger 的拦截器，用法是
+//
+//	engine.Use(pgears.QueryLogger(slog.Default(), pgears.LogOptions{SlowThreshold: time.Second}))
+//
+// 日志包含 op 、 sql 、参数（敏感字段已替换，见 Call.SafeArgs）、耗时、 exec 的影响行数
+// 和错误。出错的调用用 Error 级别记录。
+// 对查询来说耗时只统计到拿到结果集为止，不包括遍历结果集的时间
+func QueryLogger(logger *slog.Logger, opts LogOptions) Interceptor {
+	return func(call *Call, next Handler) error {
+		var start = time.Now()
+		var err = next(call)
+		var elapsed = time.Since(start)
+
+		var level = opts.Level
+		var msg = "pgears sql"
+		var attrs = []slog.Attr{
+			slog.String("op", call.Op),
+			slog.String("kind", call.Kind),
+			slog.String("sql", call.SQL),
+		}
+		if call.Table != "" {
+			attrs = append(attrs, slog.String("table", call.Table))
+		}
+		if call.Kind != KindPrepare {
+			attrs = append(attrs, slog.Any("args", call.SafeArgs()))
+		}
+		attrs = append(attrs, slog.Duration("duration", elapsed))
+		if call.InTran {
+			attrs = append(attrs, slog.Bool("tran", true))
+		}
+		if err == nil && call.Result != nil {
+			if affected, e := call.Result.RowsAffected(); e == nil {
+				attrs = append(attrs, slog.Int64("rows_affected", affected))
+			}
+		}
+
+		if err != nil {
+			level = slog.LevelError
+			attrs = append(attrs, slog.String("error", err.Error()))
+		} else if opts.SlowThreshold > 0 && elapsed >= opts.SlowThreshold {
+			level = slog.LevelWarn
+			msg = "pgears slow sql"
+			if opts.Explain && call.Kind != KindPrepare {
+				if plan, e := explain(call); e == nil {
+					attrs = append(attrs, slog.String("plan", plan))
+				} else {
+					attrs = append(attrs, slog.String("explain_error", e.Error()))
+				}
+			}
+		}
+		logger.LogAttrs(call.Context, level, msg, attrs...)
+		return err
+	}
+}
+
+// explain 用 EXPLAIN 取得 call 的执行计划，每行计划取最后一列。
+// 它直接走连接池而不经过拦截器，所以事务中还没提交的改动是看不到的
+func explain(call *Call) (string, error) {
+	if call.db == nil {
+		return "", fmt.Errorf("call %s has no engine to explain with", call.Op)
+	}
+	var prefix = "EXPLAIN "
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+	rows, err := call.db.DB.Query(prefix+sqliteParams(call.SQL), call.Args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	var lines = make([]string, 0)
+	var slots = make([]interface{}, len(cols))
+	for rows.Next() {
+		var values = make([]interface{}, len(cols))
+		for i := range values {
+			slots[i] = &values[i]
+		}
+		if err := rows.Scan(slots...); err != nil {
+			return "", err
+		}
+		var line = values[len(values)-1]
+		if b, ok := line.([]byte); ok {
+			lines = append(lines, string(b))
+		} else {
+			lines = append(lines, fmt.Sprint(line))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,327 @@
+// migrate 包按版本号顺序执行数据库迁移，已经执行过的迁移记录在 schema_migrations 表里。
+//
+// 迁移可以是 SQL 文件（见 LoadDir），也可以是用 Add 注册的 Go 函数。每个迁移在各自的事务中
+// 执行，成功以后才写入记录。PostgreSQL 下整个过程持有一个 advisory lock ，多个进程同时
+// 执行迁移的时候后来的会等前面的结束；SQLite 本身只有一个写者，不需要额外的锁。
+//
+// 命令行工具见 cmd/pgears-migrate
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/Dwarfartisan/pgears"
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+// DefaultTable 是默认的迁移记录表
+const DefaultTable = "schema_migrations"
+
+// Migration 是一个迁移。 UpSQL/DownSQL 和 Up/Down 二选一，同时给出的话先执行 SQL
+type Migration struct {
+	Version int64
+	Name    string
+	UpSQL   string
+	DownSQL string
+	Up      func(tx *sql.Tx) error
+	Down    func(tx *sql.Tx) error
+}
+
+// String 给出 version_name 形式的名字，跟迁移文件名的前缀一致
+func (mig *Migration) String() string {
+	return fmt.Sprintf("%d_%s", mig.Version, mig.Name)
+}
+
+func (mig *Migration) hasDown() bool {
+	return mig.DownSQL != "" || mig.Down != nil
+}
+
+// Status 是一个迁移的执行状态。数据库中有记录但是找不到迁移定义的， Missing 为 true
+type Status struct {
+	Migration *Migration
+	Applied   bool
+	AppliedAt time.Time
+	Missing   bool
+}
+
+// Migrator 管理一组迁移，用 New 构造
+type Migrator struct {
+	// Table 是记录已执行迁移的表，默认是 DefaultTable
+	Table string
+	// DryRun 为 true 的时候只把要执行的 SQL 写到 Out ，不真正执行，也不写记录
+	DryRun bool
+	// Out 是执行过程的输出，默认是 os.Stdout
+	Out io.Writer
+
+	db         *sql.DB
+	migrations map[int64]*Migration
+}
+
+// New 构造 engine 上的 Migrator ，数据库类型跟随 CreateEngine 的设定
+func New(engine *pgears.Engine) *Migrator {
+	return &Migrator{Table: DefaultTable, Out: os.Stdout,
+		db: engine.DB, migrations: make(map[int64]*Migration)}
+}
+
+// Add 注册迁移，版本号重复的话返回错误
+func (m *Migrator) Add(migrations ...*Migration) error {
+	for _, mig := range migrations {
+		if old, ok := m.migrations[mig.Version]; ok {
+			return fmt.Errorf("migration %s and %s have the same version", old, mig)
+		}
+		m.migrations[mig.Version] = mig
+	}
+	return nil
+}
+
+// Migrations 按版本号顺序返回所有注册的迁移
+func (m *Migrator) Migrations() []*Migration {
+	var ret = make([]*Migration, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		ret = append(ret, mig)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Version < ret[j].Version })
+	return ret
+}
+
+// Status 按版本号顺序给出所有迁移的状态，包括数据库里有记录但是已经没有定义的迁移
+func (m *Migrator) Status() ([]Status, error) {
+	var ret []Status
+	var err = m.session(func(ctx context.Context, conn *sql.Conn) error {
+		var applied, err = m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.Migrations() {
+			var at, ok = applied[mig.Version]
+			ret = append(ret, Status{Migration: mig, Applied: ok, AppliedAt: at.at})
+			delete(applied, mig.Version)
+		}
+		for version, at := range applied {
+			ret = append(ret, Status{Migration: &Migration{Version: version, Name: at.name},
+				Applied: true, AppliedAt: at.at, Missing: true})
+		}
+		sort.Slice(ret, func(i, j int) bool { return ret[i].Migration.Version < ret[j].Migration.Version })
+		return nil
+	})
+	return ret, err
+}
+
+// Up 按版本号顺序执行还没有执行的迁移， steps 为 0 表示全部执行，否则最多执行 steps 个。
+// 返回执行了的迁移，出错的话停在出错的那个迁移，它之前的迁移仍然有效
+func (m *Migrator) Up(steps int) ([]*Migration, error) {
+	var done []*Migration
+	var err = m.session(func(ctx context.Context, conn *sql.Conn) error {
+		var applied, err = m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.Migrations() {
+			if steps > 0 && len(done) >= steps {
+				break
+			}
+			if _, ok := applied[mig.Version]; ok {
+				continue
+			}
+			if err := m.run(ctx, conn, mig, true); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Rollback 按版本号倒序回滚最近执行的 steps 个迁移， steps 小于 1 的时候按 1 算
+func (m *Migrator) Rollback(steps int) ([]*Migration, error) {
+	if steps < 1 {
+		steps = 1
+	}
+	var done []*Migration
+	var err = m.session(func(ctx context.Context, conn *sql.Conn) error {
+		var migs, err = m.latest(ctx, conn, steps)
+		if err != nil {
+			return err
+		}
+		for _, mig := range migs {
+			if err := m.run(ctx, conn, mig, false); err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Redo 回滚最近执行的一个迁移再重新执行它，用于开发时修改迁移
+func (m *Migrator) Redo() (*Migration, error) {
+	var done *Migration
+	var err = m.session(func(ctx context.Context, conn *sql.Conn) error {
+		var migs, err = m.latest(ctx, conn, 1)
+		if err != nil {
+			return err
+		}
+		if len(migs) == 0 {
+			return errors.New("there is no applied migration to redo")
+		}
+		if err := m.run(ctx, conn, migs[0], false); err != nil {
+			return err
+		}
+		if err := m.run(ctx, conn, migs[0], true); err != nil {
+			return err
+		}
+		done = migs[0]
+		return nil
+	})
+	return done, err
+}
+
+// latest 按版本号倒序取最近执行的 n 个迁移，它们都必须有定义和 down 部分
+func (m *Migrator) latest(ctx context.Context, conn *sql.Conn, n int) ([]*Migration, error) {
+	var applied, err = m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	var versions = make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	if len(versions) > n {
+		versions = versions[:n]
+	}
+	var ret = make([]*Migration, 0, len(versions))
+	for _, version := range versions {
+		var mig, ok = m.migrations[version]
+		if !ok {
+			return nil, fmt.Errorf("migration %d_%s is applied but can't be found",
+				version, applied[version].name)
+		}
+		if !mig.hasDown() {
+			return nil, fmt.Errorf("migration %s has no down part", mig)
+		}
+		ret = append(ret, mig)
+	}
+	return ret, nil
+}
+
+// session 在一个独占的连接上执行 fn ，PostgreSQL 下先取得 advisory lock
+func (m *Migrator) session(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	var ctx = context.Background()
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if dbdriver.Sqltype == dbdriver.DB_POSTGRES {
+		var key = m.lockKey()
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", key); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", key)
+	}
+	if !m.DryRun {
+		var ddl = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (version bigint PRIMARY KEY, "+
+			"name text NOT NULL, applied_at timestamp NOT NULL)", m.Table)
+		if _, err := conn.ExecContext(ctx, ddl); err != nil {
+			return err
+		}
+	}
+	return fn(ctx, conn)
+}
+
+// lockKey 由记录表的名字得到 advisory lock 的键，不同的记录表互不影响
+func (m *Migrator) lockKey() int64 {
+	var h = fnv.New64a()
+	h.Write([]byte("pgears.migrate." + m.Table))
+	return int64(h.Sum64())
+}
+
+type appliedRow struct {
+	name string
+	at   time.Time
+}
+
+// applied 读出已经执行的迁移。 dry run 的时候记录表可能还不存在，这时当作一个都没执行
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]appliedRow, error) {
+	var ret = make(map[int64]appliedRow)
+	rows, err := conn.QueryContext(ctx,
+		fmt.Sprintf("SELECT version, name, applied_at FROM %s", m.Table))
+	if err != nil {
+		if m.DryRun {
+			return ret, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int64
+		var row appliedRow
+		if err := rows.Scan(&version, &row.name, &row.at); err != nil {
+			return nil, err
+		}
+		ret[version] = row
+	}
+	return ret, rows.Err()
+}
+
+// run 在事务中执行一个迁移的 up 或 down 部分，并且更新记录表
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, mig *Migration, up bool) error {
+	var direction, script, fn = "up", mig.UpSQL, mig.Up
+	if !up {
+		direction, script, fn = "down", mig.DownSQL, mig.Down
+	}
+	if m.DryRun {
+		fmt.Fprintf(m.Out, "-- %s %s\n", direction, mig)
+		if script != "" {
+			fmt.Fprintln(m.Out, script)
+		}
+		if fn != nil {
+			fmt.Fprintln(m.Out, "-- (go migration)")
+		}
+		return nil
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := m.apply(tx, mig, up, script, fn); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %s %s failed: %v", mig, direction, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	fmt.Fprintf(m.Out, "%s %s\n", direction, mig)
+	return nil
+}
+
+func (m *Migrator) apply(tx *sql.Tx, mig *Migration, up bool, script string, fn func(*sql.Tx) error) error {
+	if script != "" {
+		if _, err := tx.Exec(script); err != nil {
+			return err
+		}
+	}
+	if fn != nil {
+		if err := fn(tx); err != nil {
+			return err
+		}
+	}
+	if up {
+		var _, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (version, name, applied_at) VALUES ($1, $2, $3)",
+			m.Table), mig.Version, mig.Name, time.Now().UTC())
+		return err
+	}
+	var _, err = tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE version = $1", m.Table), mig.Version)
+	return err
+}
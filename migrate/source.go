@@ -0,0 +1,122 @@
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Dwarfartisan/pgears"
+)
+
+// migration 文件名的格式是 <version>_<name>.up.sql 和 <version>_<name>.down.sql
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// LoadDir 从目录中加载 SQL 迁移文件，见 LoadFS
+func (m *Migrator) LoadDir(dir string) error {
+	return m.LoadFS(os.DirFS(dir), ".")
+}
+
+// LoadFS 从 fsys 的 dir 目录中加载 SQL 迁移文件，文件名是
+// <version>_<name>.up.sql 和 <version>_<name>.down.sql ， down 文件可以没有。
+// 不符合这个格式的文件会被忽略，这样可以跟 go:embed 一起使用
+func (m *Migrator) LoadFS(fsys fs.FS, dir string) error {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return err
+	}
+	var loaded = make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		var match = fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid migration version in %s: %v", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		var mig, ok = loaded[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			loaded[version] = mig
+		} else if mig.Name != match[2] {
+			return fmt.Errorf("migration %d has two names %s and %s", version, mig.Name, match[2])
+		}
+		if match[3] == "up" {
+			mig.UpSQL = string(content)
+		} else {
+			mig.DownSQL = string(content)
+		}
+	}
+	for _, mig := range loaded {
+		if mig.UpSQL == "" {
+			return fmt.Errorf("migration %s has no up file", mig)
+		}
+		if err := m.Add(mig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create 在 dir 中生成一对空的迁移文件，版本号取当前 UTC 时间的 yyyymmddhhmmss ，
+// 返回两个文件的路径
+func Create(dir, name string) (up, down string, err error) {
+	return writeFiles(dir, name, "", "")
+}
+
+// writeFiles 生成迁移文件，文件开头是一行带名字的注释
+func writeFiles(dir, name, upSQL, downSQL string) (up, down string, err error) {
+	name = strings.ReplaceAll(strings.TrimSpace(name), " ", "_")
+	if name == "" {
+		return "", "", fmt.Errorf("migration name is empty")
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	var prefix = fmt.Sprintf("%s_%s", time.Now().UTC().Format("20060102150405"), name)
+	up = filepath.Join(dir, prefix+".up.sql")
+	down = filepath.Join(dir, prefix+".down.sql")
+	if err = os.WriteFile(up, []byte("-- "+prefix+" up\n"+upSQL), 0644); err != nil {
+		return "", "", err
+	}
+	if err = os.WriteFile(down, []byte("-- "+prefix+" down\n"+downSQL), 0644); err != nil {
+		return "", "", err
+	}
+	return up, down, nil
+}
+
+// WriteChanges 把 Engine.SchemaDiff 给出的变更写成一对迁移文件， down 文件按相反的顺序写入
+// 各个变更的 Down ，没法逆转的变更写成一行注释。没有变更的话不生成文件，返回空路径
+func WriteChanges(dir, name string, changes []pgears.SchemaChange) (up, down string, err error) {
+	if len(changes) == 0 {
+		return "", "", nil
+	}
+	var ups, downs strings.Builder
+	for _, change := range changes {
+		ups.WriteString(change.SQL)
+		ups.WriteString("\n")
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		var change = changes[i]
+		if change.Down != "" {
+			downs.WriteString(change.Down)
+		} else {
+			fmt.Fprintf(&downs, "-- %s on %s can't be reverted automatically", change.Kind, change.Table)
+		}
+		downs.WriteString("\n")
+	}
+	return writeFiles(dir, name, ups.String(), downs.String())
+}
@@ -0,0 +1,61 @@
+// naming.go 定义没有写 field 标签的字段、没有给出表名的类型怎样得到数据库中的名字
+package pgears
+
+import (
+	"strings"
+	"unicode"
+)
+
+// NamingStrategy 把 Go 的类型名和字段名转换成表名和字段名。标签和 MapStructTo 中明确
+// 给出的名字总是优先的，只有省略的时候才用它
+type NamingStrategy interface {
+	TableName(typeName string) string
+	ColumnName(fieldName string) string
+}
+
+// NamingFunc 用同一个函数转换表名和字段名，自定义的规则可以直接写成这个类型
+type NamingFunc func(name string) string
+
+// TableName 实现 NamingStrategy
+func (f NamingFunc) TableName(typeName string) string {
+	return f(typeName)
+}
+
+// ColumnName 实现 NamingStrategy
+func (f NamingFunc) ColumnName(fieldName string) string {
+	return f(fieldName)
+}
+
+// 内置的命名规则， Engine 默认使用 SnakeCase
+var (
+	// SnakeCase 把 CreatedAt 转成 created_at ，连续的大写按缩写处理， UserID 转成 user_id
+	SnakeCase NamingStrategy = NamingFunc(ToSnakeCase)
+	// LowerCase 只转成小写， CreatedAt 转成 createdat
+	LowerCase NamingStrategy = NamingFunc(strings.ToLower)
+	// Identity 原样使用 Go 的名字
+	Identity NamingStrategy = NamingFunc(func(name string) string { return name })
+)
+
+// ToSnakeCase 把驼峰式的名字转成下划线分隔的小写名字
+func ToSnakeCase(name string) string {
+	var runes = []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			var prev = runes[i-1]
+			var nextLower = i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			// aB 、 1B 和 ABc 中的 B 前面要断开
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// SetNamingStrategy 设置 Engine 的命名规则，只影响之后注册的类型，请在 MapStructTo 和
+// RegistStruct 之前调用
+func (e *Engine) SetNamingStrategy(naming NamingStrategy) {
+	e.naming = naming
+}
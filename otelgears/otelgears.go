@@ -0,0 +1,215 @@
+// otelgears 包给 pgears 提供 OpenTelemetry 的追踪和指标。它通过 pgears 的拦截器链工作，
+// 不使用的话 pgears 本身不依赖 OpenTelemetry
+//
+//	if err := otelgears.Instrument(engine); err != nil {
+//		...
+//	}
+//
+// 每次经过 Engine 、 Tran 、 Query 的数据库调用都会生成一个 client span ，带上
+// db.system 、 db.statement 、 db.operation 、 db.sql.table 等属性，同时记录耗时直方图、
+// 错误计数，以及从 sql.DB.Stats() 读取的连接池状态。
+// 测试时可以用 WithTracerProvider 和 WithMeterProvider 传入 in-memory exporter 的 provider
+package otelgears
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Dwarfartisan/pgears"
+	"github.com/Dwarfartisan/pgears/dbdriver"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ScopeName 是 tracer 和 meter 使用的 instrumentation scope
+const ScopeName = "github.com/Dwarfartisan/pgears/otelgears"
+
+type config struct {
+	tracerProvider trace.TracerProvider
+	meterProvider  metric.MeterProvider
+	statement      bool
+}
+
+// Option 是 Instrument 的配置项
+type Option func(*config)
+
+// WithTracerProvider 指定创建 span 用的 TracerProvider ，默认是 otel.GetTracerProvider()
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tracerProvider = provider
+	}
+}
+
+// WithMeterProvider 指定记录指标用的 MeterProvider ，默认是 otel.GetMeterProvider()
+func WithMeterProvider(provider metric.MeterProvider) Option {
+	return func(c *config) {
+		c.meterProvider = provider
+	}
+}
+
+// WithoutStatement 让 span 不带 db.statement 属性，SQL 本身不能外传的时候用
+func WithoutStatement() Option {
+	return func(c *config) {
+		c.statement = false
+	}
+}
+
+// Instrument 给 engine 加上追踪和指标的拦截器，并注册连接池状态的指标。
+// 跟其它拦截器一样，请在开始使用 engine 之前调用
+func Instrument(engine *pgears.Engine, opts ...Option) error {
+	var c = config{otel.GetTracerProvider(), otel.GetMeterProvider(), true}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	var ins = &instrument{
+		tracer:    c.tracerProvider.Tracer(ScopeName),
+		statement: c.statement,
+	}
+	var meter = c.meterProvider.Meter(ScopeName)
+	var err error
+	ins.duration, err = meter.Float64Histogram("db.client.operation.duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Duration of database client operations."))
+	if err != nil {
+		return err
+	}
+	ins.errors, err = meter.Int64Counter("db.client.operation.errors",
+		metric.WithUnit("{error}"),
+		metric.WithDescription("Number of failed database client operations."))
+	if err != nil {
+		return err
+	}
+	if err = observeStats(meter, engine); err != nil {
+		return err
+	}
+	engine.Use(ins.intercept)
+	return nil
+}
+
+type instrument struct {
+	tracer    trace.Tracer
+	statement bool
+	duration  metric.Float64Histogram
+	errors    metric.Int64Counter
+}
+
+// intercept 是挂到 Engine 上的拦截器，它把 span 放进 call.Context 传给后面的拦截器
+func (ins *instrument) intercept(call *pgears.Call, next pgears.Handler) error {
+	var operation = operationOf(call)
+	var attrs = []attribute.KeyValue{
+		attribute.String("db.system", dbSystem()),
+		attribute.String("db.operation", operation),
+		attribute.String("pgears.op", call.Op),
+		attribute.String("pgears.kind", call.Kind),
+	}
+	if call.Table != "" {
+		attrs = append(attrs, attribute.String("db.sql.table", call.Table))
+	}
+	var spanAttrs = attrs
+	if ins.statement {
+		spanAttrs = append(spanAttrs[:len(spanAttrs):len(spanAttrs)],
+			attribute.String("db.statement", call.SQL))
+	}
+	if call.InTran {
+		spanAttrs = append(spanAttrs, attribute.Bool("pgears.tran", true))
+	}
+
+	var ctx, span = ins.tracer.Start(call.Context, spanName(operation, call.Table),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(spanAttrs...))
+	defer span.End()
+	call.Context = ctx
+
+	var start = time.Now()
+	var err = next(call)
+	var elapsed = time.Since(start)
+
+	if err == nil && call.Result != nil {
+		if affected, e := call.Result.RowsAffected(); e == nil {
+			span.SetAttributes(attribute.Int64("db.rows_affected", affected))
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		ins.errors.Add(ctx, 1, metric.WithAttributes(attrs...))
+	}
+	ins.duration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(attrs...))
+	return err
+}
+
+// observeStats 注册连接池状态的 observable 指标，采集时读取 engine.Stats()
+func observeStats(meter metric.Meter, engine *pgears.Engine) error {
+	open, err := meter.Int64ObservableGauge("db.client.connections.open",
+		metric.WithDescription("Number of established connections, both in use and idle."))
+	if err != nil {
+		return err
+	}
+	inUse, err := meter.Int64ObservableGauge("db.client.connections.in_use",
+		metric.WithDescription("Number of connections currently in use."))
+	if err != nil {
+		return err
+	}
+	idle, err := meter.Int64ObservableGauge("db.client.connections.idle",
+		metric.WithDescription("Number of idle connections."))
+	if err != nil {
+		return err
+	}
+	maxOpen, err := meter.Int64ObservableGauge("db.client.connections.max",
+		metric.WithDescription("Maximum number of open connections, 0 means unlimited."))
+	if err != nil {
+		return err
+	}
+	waitCount, err := meter.Int64ObservableCounter("db.client.connections.wait_count",
+		metric.WithDescription("Total number of connections waited for."))
+	if err != nil {
+		return err
+	}
+	waitDuration, err := meter.Float64ObservableCounter("db.client.connections.wait_duration",
+		metric.WithUnit("s"),
+		metric.WithDescription("Total time blocked waiting for a new connection."))
+	if err != nil {
+		return err
+	}
+	var attrs = metric.WithAttributes(attribute.String("db.system", dbSystem()))
+	_, err = meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		var stats = engine.Stats()
+		o.ObserveInt64(open, int64(stats.OpenConnections), attrs)
+		o.ObserveInt64(inUse, int64(stats.InUse), attrs)
+		o.ObserveInt64(idle, int64(stats.Idle), attrs)
+		o.ObserveInt64(maxOpen, int64(stats.MaxOpenConnections), attrs)
+		o.ObserveInt64(waitCount, stats.WaitCount, attrs)
+		o.ObserveFloat64(waitDuration, stats.WaitDuration.Seconds(), attrs)
+		return nil
+	}, open, inUse, idle, maxOpen, waitCount, waitDuration)
+	return err
+}
+
+// dbSystem 按 dbdriver.Sqltype 给出 db.system 的值
+func dbSystem() string {
+	if dbdriver.Sqltype == dbdriver.DB_POSTGRES {
+		return "postgresql"
+	}
+	return "sqlite"
+}
+
+// operationOf 取 SQL 的第一个关键字作为 db.operation ， prepare 阶段也是如此
+func operationOf(call *pgears.Call) string {
+	var fields = strings.Fields(call.SQL)
+	if len(fields) == 0 {
+		return strings.ToUpper(call.Kind)
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// spanName 按语义约定用 "<db.operation> <table>" 作为 span 名
+func spanName(operation, table string) string {
+	if table == "" {
+		return operation
+	}
+	return operation + " " + table
+}
@@ -0,0 +1,237 @@
+package otelgears
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Dwarfartisan/pgears"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+type item struct {
+	ID   int `pk:"true"`
+	Name string
+}
+
+// setup 建一个内存 SQLite 的 Engine ，挂上记录到内存的 tracer 和 meter
+func setup(t *testing.T) (*pgears.Engine, *tracetest.SpanRecorder, *sdkmetric.ManualReader) {
+	engine, err := pgears.CreateEngine("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { engine.Close() })
+	engine.SetMaxOpenConns(1)
+	engine.MapStructTo(&item{}, "item")
+	if _, err := engine.Exec("CREATE TABLE item (id integer PRIMARY KEY, name text)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var recorder = tracetest.NewSpanRecorder()
+	var reader = sdkmetric.NewManualReader()
+	err = Instrument(engine,
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		WithMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return engine, recorder, reader
+}
+
+func attrsOf(kvs []attribute.KeyValue) map[attribute.Key]attribute.Value {
+	var ret = make(map[attribute.Key]attribute.Value, len(kvs))
+	for _, kv := range kvs {
+		ret[kv.Key] = kv.Value
+	}
+	return ret
+}
+
+// collect 读出一次指标，按名字索引
+func collect(t *testing.T, reader *sdkmetric.ManualReader) map[string]metricdata.Aggregation {
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	var ret = make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		if sm.Scope.Name != ScopeName {
+			t.Errorf("unexpected scope %s", sm.Scope.Name)
+		}
+		for _, m := range sm.Metrics {
+			ret[m.Name] = m.Data
+		}
+	}
+	return ret
+}
+
+func TestSpan(t *testing.T) {
+	var engine, recorder, _ = setup(t)
+	if err := engine.Insert(&item{ID: 1, Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var spans = recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expect 1 span but got %d", len(spans))
+	}
+	var span = spans[0]
+	if span.Name() != "INSERT item" {
+		t.Errorf("expect span name INSERT item but got %s", span.Name())
+	}
+	var attrs = attrsOf(span.Attributes())
+	var expect = map[attribute.Key]string{
+		"db.system":    "sqlite",
+		"db.operation": "INSERT",
+		"db.sql.table": "item",
+		"pgears.op":    "Insert",
+	}
+	for key, value := range expect {
+		if got := attrs[key].AsString(); got != value {
+			t.Errorf("expect %s=%s but got %q", key, value, got)
+		}
+	}
+	if _, ok := attrs["db.statement"]; !ok {
+		t.Error("expect db.statement attribute")
+	}
+	if got := attrs["db.rows_affected"].AsInt64(); got != 1 {
+		t.Errorf("expect db.rows_affected=1 but got %d", got)
+	}
+	if span.Status().Code != codes.Unset {
+		t.Errorf("expect status unset but got %v", span.Status())
+	}
+}
+
+func TestParentSpan(t *testing.T) {
+	var engine, recorder, _ = setup(t)
+	var provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	var ctx, parent = provider.Tracer("test").Start(context.Background(), "parent")
+	if err := engine.WithContext(ctx).Insert(&item{ID: 1, Name: "first"}); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	var spans = recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expect 2 spans but got %d", len(spans))
+	}
+	var span = spans[0]
+	if span.Name() != "INSERT item" {
+		t.Fatalf("expect span INSERT item but got %s", span.Name())
+	}
+	var expect = parent.SpanContext()
+	if got := span.Parent(); got.TraceID() != expect.TraceID() || got.SpanID() != expect.SpanID() {
+		t.Errorf("expect parent %v but got %v", expect.SpanID(), got.SpanID())
+	}
+}
+
+func TestWithoutStatement(t *testing.T) {
+	engine, err := pgears.CreateEngine("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer engine.Close()
+	var recorder = tracetest.NewSpanRecorder()
+	err = Instrument(engine, WithoutStatement(),
+		WithTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))),
+		WithMeterProvider(sdkmetric.NewMeterProvider()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := engine.ExecSQL("CREATE TABLE other (id integer)"); err != nil {
+		t.Fatal(err)
+	}
+
+	var spans = recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expect 1 span but got %d", len(spans))
+	}
+	if spans[0].Name() != "CREATE" {
+		t.Errorf("expect span name CREATE but got %s", spans[0].Name())
+	}
+	if _, ok := attrsOf(spans[0].Attributes())["db.statement"]; ok {
+		t.Error("expect no db.statement attribute")
+	}
+}
+
+func TestError(t *testing.T) {
+	var engine, recorder, reader = setup(t)
+	if _, err := engine.ExecSQL("DELETE FROM missing"); err == nil {
+		t.Fatal("expect an error from a missing table")
+	}
+
+	var spans = recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expect 1 span but got %d", len(spans))
+	}
+	if spans[0].Status().Code != codes.Error {
+		t.Errorf("expect status error but got %v", spans[0].Status())
+	}
+	if len(spans[0].Events()) == 0 || spans[0].Events()[0].Name != "exception" {
+		t.Error("expect the error recorded as an exception event")
+	}
+
+	var data = collect(t, reader)
+	errors, ok := data["db.client.operation.errors"].(metricdata.Sum[int64])
+	if !ok || len(errors.DataPoints) != 1 {
+		t.Fatalf("expect one errors data point but got %#v", data["db.client.operation.errors"])
+	}
+	var point = errors.DataPoints[0]
+	if point.Value != 1 {
+		t.Errorf("expect 1 error but got %d", point.Value)
+	}
+	if op, _ := point.Attributes.Value("db.operation"); op.AsString() != "DELETE" {
+		t.Errorf("expect db.operation DELETE but got %s", op.AsString())
+	}
+	if _, ok := point.Attributes.Value("db.statement"); ok {
+		t.Error("metric attributes should not carry db.statement")
+	}
+
+	duration, ok := data["db.client.operation.duration"].(metricdata.Histogram[float64])
+	if !ok || len(duration.DataPoints) != 1 || duration.DataPoints[0].Count != 1 {
+		t.Errorf("expect one duration record but got %#v", data["db.client.operation.duration"])
+	}
+}
+
+func TestPoolGauges(t *testing.T) {
+	var engine, _, reader = setup(t)
+	engine.SetMaxOpenConns(3)
+	var item = item{ID: 1}
+	if err := engine.Insert(&item); err != nil {
+		t.Fatal(err)
+	}
+	var stats = engine.Stats()
+
+	var data = collect(t, reader)
+	var expect = map[string]int64{
+		"db.client.connections.open":   int64(stats.OpenConnections),
+		"db.client.connections.in_use": int64(stats.InUse),
+		"db.client.connections.idle":   int64(stats.Idle),
+		"db.client.connections.max":    3,
+	}
+	for name, value := range expect {
+		gauge, ok := data[name].(metricdata.Gauge[int64])
+		if !ok || len(gauge.DataPoints) != 1 {
+			t.Errorf("expect one %s data point but got %#v", name, data[name])
+			continue
+		}
+		var point = gauge.DataPoints[0]
+		if point.Value != value {
+			t.Errorf("expect %s=%d but got %d", name, value, point.Value)
+		}
+		if system, _ := point.Attributes.Value("db.system"); system.AsString() != "sqlite" {
+			t.Errorf("expect %s with db.system sqlite but got %s", name, system.AsString())
+		}
+	}
+	if stats.OpenConnections == 0 {
+		t.Error("expect at least one open connection after insert")
+	}
+	for _, name := range []string{"db.client.connections.wait_count", "db.client.connections.wait_duration"} {
+		if _, ok := data[name]; !ok {
+			t.Errorf("expect metric %s", name)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+// schema.go 比较 MapStructTo 注册的结构和数据库中实际的表结构，生成需要的 DDL
+package pgears
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+	"github.com/Dwarfartisan/pgears/exp"
+)
+
+// SchemaChange 的 Kind 取值
+const (
+	ChangeCreateTable = "create_table"
+	ChangeAddColumn   = "add_column"
+	ChangeDropColumn  = "drop_column"
+	ChangeAlterType   = "alter_type"
+	ChangeSetNotNull  = "set_not_null"
+	ChangeDropNotNull = "drop_not_null"
+	ChangeSetDefault  = "set_default"
+	ChangeDropDefault = "drop_default"
+	ChangeCreateIndex = "create_index"
+	ChangeDropIndex   = "drop_index"
+)
+
+// SchemaChange 是结构和数据库之间的一处差异。 SQL 把数据库改成结构描述的样子， Down 是它的
+// 逆操作，没法逆转的时候为空。
+// SQLite 不支持修改字段的类型、默认值和 NOT NULL ，这些差异的 SQL 是一行说明需要重建表的注释
+type SchemaChange struct {
+	Kind   string
+	Table  string
+	Column string
+	Index  string
+	SQL    string
+	Down   string
+}
+
+// column 是数据库中一个字段的实际定义
+type column struct {
+	name     string
+	typ      string
+	notNull  bool
+	def      sql.NullString
+	identity bool
+	pk       bool
+}
+
+// liveIndex 是数据库中一个不属于约束的索引
+type liveIndex struct {
+	index
+	def string
+}
+
+// SchemaDiff 比较所有用 MapStructTo 注册的表和数据库中的实际结构，按表名顺序给出需要执行的变更，
+// 包括新建表、增删字段，字段类型、 NOT NULL 、默认值的变化，以及 index/unique_index 声明的索引。
+// RegistStruct 注册的类型不是完整的表，不参与比较。
+// 生成的语句不会自动执行，请检查以后再用，例如通过 migrate.WriteChanges 写成迁移文件
+func (e *Engine) SchemaDiff() ([]SchemaChange, error) {
+	var names = make([]string, 0, len(e.tablemap))
+	for name := range e.tablemap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var changes = make([]SchemaChange, 0)
+	for _, name := range names {
+		var table = e.tablemap[name]
+		columns, err := e.liveColumns("SchemaDiff", table.tablename)
+		if err != nil {
+			return nil, err
+		}
+		if len(columns) == 0 {
+			changes = append(changes, SchemaChange{Kind: ChangeCreateTable, Table: table.tablename,
+				SQL: table.GetCreateTableSQL(), Down: table.DropTable() + ";"})
+			for _, idx := range table.indexes() {
+				changes = append(changes, createIndex(table.tablename, idx))
+			}
+			continue
+		}
+		changes = append(changes, table.columnChanges(columns)...)
+		indexes, err := e.liveIndexes(table.tablename)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, table.indexChanges(indexes)...)
+	}
+	return changes, nil
+}
+
+// columnChanges 比较字段，结构中字段的顺序跟建表语句一致，数据库中多出来的字段按名字排序
+func (dbt *DbTable) columnChanges(live map[string]*column) []SchemaChange {
+	var changes = make([]SchemaChange, 0)
+	var tablename = dbt.tablename
+	var _, pk, other, _ = dbt.Extract()
+	var seen = make(map[string]bool)
+	for _, ep := range append(pk[:len(pk):len(pk)], other...) {
+		var goname = ep.(*exp.Field).GoName
+		var field, _ = dbt.Fields.GoGet(goname)
+		seen[field.DbName] = true
+		var col, ok = live[field.DbName]
+		if !ok {
+			changes = append(changes, SchemaChange{Kind: ChangeAddColumn, Table: tablename, Column: field.DbName,
+				SQL:  fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", tablename, dbt.columnDDL(goname)),
+				Down: fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", tablename, field.DbName)})
+			continue
+		}
+		changes = append(changes, dbt.alterColumn(goname, col)...)
+	}
+	var dropped = make([]string, 0)
+	for name := range live {
+		if !seen[name] {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(dropped)
+	for _, name := range dropped {
+		var col = live[name]
+		var ddl = fmt.Sprintf("%s %s", col.name, col.typ)
+		if col.notNull {
+			ddl += " NOT NULL"
+		}
+		if col.def.Valid {
+			ddl += " DEFAULT " + col.def.String
+		}
+		changes = append(changes, SchemaChange{Kind: ChangeDropColumn, Table: tablename, Column: name,
+			SQL:  fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;", tablename, name),
+			Down: fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s;", tablename, ddl)})
+	}
+	return changes
+}
+
+// alterColumn 比较一个两边都有的字段的类型、 NOT NULL 和默认值
+func (dbt *DbTable) alterColumn(goname string, col *column) []SchemaChange {
+	var changes = make([]SchemaChange, 0)
+	var field, _ = dbt.Fields.GoGet(goname)
+	var tablename = dbt.tablename
+	var alter = func(kind, up, down string) {
+		var change = SchemaChange{Kind: kind, Table: tablename, Column: field.DbName}
+		if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+			change.SQL = fmt.Sprintf("-- sqlite can't alter column %s.%s (%s), the table needs to be rebuilt",
+				tablename, field.DbName, kind)
+		} else {
+			change.SQL = fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s;", tablename, field.DbName, up)
+			if down != "" {
+				change.Down = fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s %s;", tablename, field.DbName, down)
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	var typ = dbt.columnType(goname)
+	// serial 和 identity 的默认值是数据库自己维护的
+	var generated = col.identity || strings.HasSuffix(typ, "serial") || strings.HasSuffix(typ, "IDENTITY") ||
+		(col.def.Valid && strings.HasPrefix(col.def.String, "nextval("))
+	if typ != "" && !sameType(typ, col.typ) {
+		// ALTER COLUMN TYPE 里不能写 serial 和 identity
+		var target = strings.TrimSuffix(strings.TrimSpace(typ), " GENERATED BY DEFAULT AS IDENTITY")
+		target = strings.NewReplacer("bigserial", "bigint", "smallserial", "smallint", "serial", "integer").Replace(target)
+		alter(ChangeAlterType, "TYPE "+target, "TYPE "+col.typ)
+	}
+	if dbdriver.Sqltype == dbdriver.DB_POSTGRES {
+		var notNull = dbt.columnNotNull(goname) || field.IsPK
+		if notNull && !col.notNull {
+			alter(ChangeSetNotNull, "SET NOT NULL", "DROP NOT NULL")
+		} else if !notNull && col.notNull {
+			alter(ChangeDropNotNull, "DROP NOT NULL", "SET NOT NULL")
+		}
+	}
+	if !generated && canonicalDefault(field.Default) != canonicalDefault(col.def.String) {
+		var down = "DROP DEFAULT"
+		if col.def.Valid {
+			down = "SET DEFAULT " + col.def.String
+		}
+		if field.Default == "" {
+			alter(ChangeDropDefault, "DROP DEFAULT", down)
+		} else {
+			alter(ChangeSetDefault, "SET DEFAULT "+field.Default, down)
+		}
+	}
+	return changes
+}
+
+// indexChanges 比较索引，名字相同但是字段或者唯一性不同的索引先删除再重建
+func (dbt *DbTable) indexChanges(live map[string]*liveIndex) []SchemaChange {
+	var changes = make([]SchemaChange, 0)
+	var tablename = dbt.tablename
+	var seen = make(map[string]bool)
+	for _, idx := range dbt.indexes() {
+		seen[idx.name] = true
+		var old, ok = live[idx.name]
+		if ok && old.unique == idx.unique && strings.Join(old.columns, ",") == strings.Join(idx.columns, ",") {
+			continue
+		}
+		if ok {
+			changes = append(changes, dropIndex(tablename, old))
+		}
+		changes = append(changes, createIndex(tablename, idx))
+	}
+	var dropped = make([]string, 0)
+	for name := range live {
+		if !seen[name] {
+			dropped = append(dropped, name)
+		}
+	}
+	sort.Strings(dropped)
+	for _, name := range dropped {
+		changes = append(changes, dropIndex(tablename, live[name]))
+	}
+	return changes
+}
+
+func createIndex(tablename string, idx *index) SchemaChange {
+	return SchemaChange{Kind: ChangeCreateIndex, Table: tablename, Index: idx.name,
+		SQL: idx.createSQL(tablename), Down: fmt.Sprintf("DROP INDEX %s;", idx.name)}
+}
+
+func dropIndex(tablename string, idx *liveIndex) SchemaChange {
+	var down = idx.def
+	if down != "" && !strings.HasSuffix(down, ";") {
+		down += ";"
+	}
+	return SchemaChange{Kind: ChangeDropIndex, Table: tablename, Index: idx.name,
+		SQL: fmt.Sprintf("DROP INDEX %s;", idx.name), Down: down}
+}
+
+// liveColumns 读取数据库中表的字段，表不存在的话返回空 map
+func (e *Engine) liveColumns(op, tablename string) (map[string]*column, error) {
+	var query string
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		query = `SELECT name, type, "notnull" != 0, dflt_value, 0, pk != 0 FROM pragma_table_info($1)`
+	} else {
+		query = `SELECT column_name,
+	CASE WHEN data_type = 'ARRAY' THEN substr(udt_name, 2) || '[]'
+		WHEN character_maximum_length IS NOT NULL THEN data_type || '(' || character_maximum_length || ')'
+		WHEN data_type = 'numeric' AND numeric_precision IS NOT NULL
+			THEN 'numeric(' || numeric_precision || ',' || numeric_scale || ')'
+		WHEN data_type = 'USER-DEFINED' THEN udt_name
+		ELSE data_type END,
+	is_nullable = 'NO', column_default, is_identity = 'YES',
+	EXISTS (SELECT 1 FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu ON kcu.constraint_name = tc.constraint_name
+			AND kcu.table_schema = tc.table_schema AND kcu.table_name = tc.table_name
+		WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = c.table_schema
+			AND tc.table_name = c.table_name AND kcu.column_name = c.column_name)
+FROM information_schema.columns c
+WHERE table_schema = current_schema() AND table_name = $1`
+	}
+	rows, err := e.query(e, op, nil, query, []interface{}{tablename}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret = make(map[string]*column)
+	for rows.Next() {
+		var col column
+		if err := rows.Scan(&col.name, &col.typ, &col.notNull, &col.def, &col.identity, &col.pk); err != nil {
+			return nil, err
+		}
+		ret[col.name] = &col
+	}
+	return ret, rows.Err()
+}
+
+// liveIndexes 读取表上不属于主键、 UNIQUE 等约束的索引。每个字段一行，按字段在索引中的
+// 位置排序（SQLite 是 index_info 的 seqno ， PostgreSQL 是 indkey 中的顺序），再拼成索引
+func (e *Engine) liveIndexes(tablename string) (map[string]*liveIndex, error) {
+	var query string
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		query = `SELECT il.name, il."unique" != 0, ii.name, coalesce(m.sql, '')
+FROM pragma_index_list($1) AS il
+JOIN pragma_index_info(il.name) AS ii
+LEFT JOIN sqlite_master AS m ON m.type = 'index' AND m.name = il.name
+WHERE il.origin = 'c'
+ORDER BY il.name, ii.seqno`
+	} else {
+		query = `SELECT i.relname, ix.indisunique, a.attname, pg_get_indexdef(ix.indexrelid)
+FROM pg_index ix
+JOIN pg_class i ON i.oid = ix.indexrelid
+JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, n) ON true
+JOIN pg_attribute a ON a.attrelid = ix.indrelid AND a.attnum = k.attnum
+LEFT JOIN pg_constraint c ON c.conindid = ix.indexrelid
+WHERE ix.indrelid = to_regclass($1) AND c.oid IS NULL
+ORDER BY i.relname, k.n`
+	}
+	rows, err := e.query(e, "SchemaDiff", nil, query, []interface{}{tablename}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ret = make(map[string]*liveIndex)
+	for rows.Next() {
+		var idx liveIndex
+		var col string
+		if err := rows.Scan(&idx.name, &idx.unique, &col, &idx.def); err != nil {
+			return nil, err
+		}
+		if old, ok := ret[idx.name]; ok {
+			old.columns = append(old.columns, col)
+		} else {
+			idx.columns = []string{col}
+			ret[idx.name] = &idx
+		}
+	}
+	return ret, rows.Err()
+}
+
+// 类型的别名，都换成 information_schema 里的写法再比较
+var typeAliases = map[string]string{
+	"int": "integer", "int4": "integer", "serial": "integer", "serial4": "integer",
+	"int8": "bigint", "bigserial": "bigint", "serial8": "bigint",
+	"int2": "smallint", "smallserial": "smallint", "serial2": "smallint",
+	"bool": "boolean", "float8": "double precision", "float4": "real",
+	"varchar": "character varying", "char": "character", "bpchar": "character",
+	"timestamptz": "timestamp with time zone", "timestamp": "timestamp without time zone",
+	"timetz": "time with time zone", "time": "time without time zone", "decimal": "numeric",
+}
+
+var typeName = regexp.MustCompile(`^([a-z][a-z0-9 ]*?)\s*(\(.*\))?(\[\])?$`)
+
+// canonicalType 规范化类型名，去掉 identity 声明，统一别名、大小写和空白
+func canonicalType(typ string) string {
+	typ = strings.ToLower(strings.Join(strings.Fields(typ), " "))
+	typ = strings.TrimSuffix(typ, " generated by default as identity")
+	typ = strings.TrimSuffix(typ, " generated always as identity")
+	var match = typeName.FindStringSubmatch(typ)
+	if match == nil {
+		return typ
+	}
+	var name = match[1]
+	if alias, ok := typeAliases[name]; ok {
+		name = alias
+	}
+	var args = strings.ReplaceAll(match[2], " ", "")
+	if name == "numeric" && args != "" && !strings.Contains(args, ",") {
+		// information_schema 里 numeric(20) 写作 numeric(20,0)
+		args = strings.TrimSuffix(args, ")") + ",0)"
+	}
+	return name + args + match[3]
+}
+
+// sameType 判断结构要求的类型和数据库中的类型是否一致。 SQLite 的字段只有类型亲和性，
+// INTEGER 、 int 、 bigint 是一回事，所以按亲和性比较
+func sameType(want, have string) bool {
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		return sqliteAffinity(want) == sqliteAffinity(have)
+	}
+	return canonicalType(want) == canonicalType(have)
+}
+
+// sqliteAffinity 按 SQLite 文档里的规则从声明的类型得到类型亲和性
+func sqliteAffinity(typ string) string {
+	typ = strings.ToLower(typ)
+	switch {
+	case strings.Contains(typ, "int"):
+		return "integer"
+	case strings.Contains(typ, "char") || strings.Contains(typ, "clob") || strings.Contains(typ, "text"):
+		return "text"
+	case typ == "" || strings.Contains(typ, "blob"):
+		return "blob"
+	case strings.Contains(typ, "real") || strings.Contains(typ, "floa") || strings.Contains(typ, "doub"):
+		return "real"
+	}
+	return "numeric"
+}
+
+var castSuffix = regexp.MustCompile(`::[a-z][a-z0-9_ ]*(\([0-9, ]*\))?(\[\])?`)
+
+// canonicalDefault 规范化默认值表达式，去掉 PostgreSQL 加上的类型转换和外层括号
+func canonicalDefault(def string) string {
+	def = strings.ToLower(strings.TrimSpace(def))
+	def = castSuffix.ReplaceAllString(def, "")
+	for strings.HasPrefix(def, "(") && strings.HasSuffix(def, ")") {
+		def = strings.TrimSpace(def[1 : len(def)-1])
+	}
+	if def == "now()" || def == "current_timestamp" {
+		return "current_timestamp"
+	}
+	return def
+}
@@ -0,0 +1,59 @@
+// session.go 提供直接执行手写 SQL 的接口，它们同样经过拦截器链。pgears-gen queries
+// 从 .sql 文件生成的函数就是通过这里执行的
+package pgears
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Session 是 Engine 和 Tran 共有的执行手写 SQL 的方法，接受它的函数可以在连接池上执行，
+// 也可以在事务里执行
+type Session interface {
+	QuerySQL(obj interface{}, query string, args ...interface{}) (*ResultSet, error)
+	ExecSQL(query string, args ...interface{}) (sql.Result, error)
+}
+
+// QuerySQL 执行一条手写的查询。 obj 是注册过的结构的指针（可以是 nil 指针，例如
+// (*Account)(nil)），返回的 ResultSet 可以用 Load 按字段名加载这个类型的对象；
+// 只用 Scan 或者 Scalar 读结果的话 obj 传 nil 就行
+func (e *Engine) QuerySQL(obj interface{}, query string, args ...interface{}) (*ResultSet, error) {
+	return e.querySQL(e, obj, query, args)
+}
+
+// ExecSQL 执行一条不返回结果集的手写语句
+func (e *Engine) ExecSQL(query string, args ...interface{}) (sql.Result, error) {
+	return e.exec(e, "ExecSQL", nil, query, args, nil)
+}
+
+// QuerySQL 是在事务中执行的版本
+func (tran *Tran) QuerySQL(obj interface{}, query string, args ...interface{}) (*ResultSet, error) {
+	return tran.db.querySQL(tran.Tx, obj, query, args)
+}
+
+// ExecSQL 是在事务中执行的版本
+func (tran *Tran) ExecSQL(query string, args ...interface{}) (sql.Result, error) {
+	return tran.db.exec(tran.Tx, "ExecSQL", nil, query, args, nil)
+}
+
+func (e *Engine) querySQL(exec Executor, obj interface{}, query string, args []interface{}) (*ResultSet, error) {
+	var typ reflect.Type
+	var table *DbTable
+	if obj != nil {
+		typ = reflect.TypeOf(obj).Elem()
+		if m, ok := e.gomap[typ]; ok {
+			table = m
+		} else {
+			var message = fmt.Sprintf("%s is't a regiested type",
+				fullGoName(typ))
+			return nil, errors.New(message)
+		}
+	}
+	rows, err := e.query(exec, "QuerySQL", typ, query, args, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ResultSet{rows, table}, nil
+}
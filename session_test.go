@@ -0,0 +1,65 @@
+package pgears
+
+import (
+	"testing"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+type pair struct {
+	ID   int `pk:"true"`
+	Name string
+}
+
+// sqliteEngine 建一个内存 SQLite 的 Engine ，注册 pair 并建好表
+func sqliteEngine(t *testing.T) *Engine {
+	engine, err := CreateEngine("sqlite://:memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { engine.Close() })
+	engine.SetMaxOpenConns(1)
+	engine.MapStructTo(&pair{}, "pair")
+	if _, err := engine.Exec("CREATE TABLE pair (id integer PRIMARY KEY, name text)"); err != nil {
+		t.Fatal(err)
+	}
+	return engine
+}
+
+func TestSqliteParams(t *testing.T) {
+	var sqltype = dbdriver.Sqltype
+	dbdriver.Sqltype = dbdriver.DB_SQLITE
+	defer func() { dbdriver.Sqltype = sqltype }()
+	var cases = map[string]string{
+		"SELECT $2, $1":                     "SELECT ?2, ?1",
+		"SELECT '$1', \"$2\" FROM t$1":      "SELECT '$1', \"$2\" FROM t$1",
+		"SELECT 'it''s $1', $1 -- $2\n, $2": "SELECT 'it''s $1', ?1 -- $2\n, ?2",
+	}
+	for query, expect := range cases {
+		if got := sqliteParams(query); got != expect {
+			t.Errorf("%q: expect %q but got %q", query, expect, got)
+		}
+	}
+}
+
+func TestSQLOutOfOrder(t *testing.T) {
+	var engine = sqliteEngine(t)
+	if _, err := engine.ExecSQL("INSERT INTO pair (name, id) VALUES ($2, $1)", 1, "first"); err != nil {
+		t.Fatal(err)
+	}
+	rset, err := engine.QuerySQL((*pair)(nil), "SELECT id, name FROM pair WHERE name = $2 AND id = $1", 1, "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rset.Close()
+	if !rset.Next() {
+		t.Fatal("expect the row inserted with out of order args")
+	}
+	var obj pair
+	if err := rset.Load(&obj); err != nil {
+		t.Fatal(err)
+	}
+	if obj.ID != 1 || obj.Name != "first" {
+		t.Errorf("expect {1 first} but got %+v", obj)
+	}
+}
@@ -0,0 +1,97 @@
+// tracker.go 提供基于快照的脏字段跟踪。 Track 记下对象当前的字段值， Save 的时候只更新
+// 跟快照相比变化了的字段
+package pgears
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// tracker 保存 Track 记录的对象快照，key 是对象指针。 Unscoped 得到的 Engine 跟原来的共享同一个 tracker
+type tracker struct {
+	sync.Mutex
+	snapshots map[interface{}]map[string]interface{}
+}
+
+func newTracker() *tracker {
+	return &tracker{snapshots: make(map[interface{}]map[string]interface{})}
+}
+
+// Track 记录 obj 当前所有非主键字段的快照，obj 必须是已注册类型的指针。
+// 快照会一直保留到 Untrack ，不再使用的对象记得释放
+func (e *Engine) Track(obj interface{}) error {
+	var typ = reflect.TypeOf(obj).Elem()
+	if m, ok := e.gomap[typ]; ok {
+		var snapshot = takeSnapshot(m, reflect.ValueOf(obj).Elem())
+		e.tracker.Lock()
+		defer e.tracker.Unlock()
+		e.tracker.snapshots[obj] = snapshot
+		return nil
+	} else {
+		var message = fmt.Sprintf("%v is't a regiested type",
+			fullGoName(typ))
+		return errors.New(message)
+	}
+}
+
+// Untrack 丢弃 obj 的快照
+func (e *Engine) Untrack(obj interface{}) {
+	e.tracker.Lock()
+	defer e.tracker.Unlock()
+	delete(e.tracker.snapshots, obj)
+}
+
+// Dirty 返回 obj 跟快照相比发生了变化的字段名，没有 Track 过的对象返回 false
+func (e *Engine) Dirty(obj interface{}) ([]string, bool) {
+	e.tracker.Lock()
+	var snapshot, ok = e.tracker.snapshots[obj]
+	e.tracker.Unlock()
+	if !ok {
+		return nil, false
+	}
+	var typ = reflect.TypeOf(obj).Elem()
+	var m = e.gomap[typ]
+	var current = takeSnapshot(m, reflect.ValueOf(obj).Elem())
+	var dirty = make([]string, 0)
+	for _, name := range m.NPk.GoKeys() {
+		if !reflect.DeepEqual(snapshot[name], current[name]) {
+			dirty = append(dirty, name)
+		}
+	}
+	return dirty, true
+}
+
+// Save 把 Track 过的对象中变化了的字段写回数据库，然后刷新快照，没有变化的话什么也不做。
+// 没有 Track 过的对象按 Update 的逻辑更新所有字段
+func (e *Engine) Save(obj interface{}) error {
+	var dirty, ok = e.Dirty(obj)
+	if !ok {
+		return e.Update(obj)
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+	if err := e.UpdateFields(obj, dirty...); err != nil {
+		return err
+	}
+	return e.Track(obj)
+}
+
+// takeSnapshot 按 ExtractField 的规则提取所有非主键字段的值，jsonto 字段保存的是序列化
+// 以后的内容，这样比较的时候不会受到 map 和指针的影响
+func takeSnapshot(m *DbTable, val reflect.Value) map[string]interface{} {
+	var snapshot = make(map[string]interface{}, m.NPk.Length())
+	for _, name := range m.NPk.GoKeys() {
+		var dbf, _ = m.NPk.GoGet(name)
+		var fv = fieldOf(val, dbf, false)
+		var v = ExtractField(fv, dbf.field)
+		if fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			// 指针字段要记下指向的值，否则通过同一个指针修改的内容是比较不出来的
+			v = fv.Elem().Interface()
+		}
+		snapshot[name] = v
+	}
+	return snapshot
+}
@@ -0,0 +1,296 @@
+// validate.go 在启动时检查注册的结构跟数据库中的表是否一致，免得到了线上才在 Scan 的时候出错
+package pgears
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Dwarfartisan/pgears/dbdriver"
+)
+
+// SchemaIssue 是 Validate 发现的一个问题， Warning 为 true 的问题不影响读写，只是值得注意
+type SchemaIssue struct {
+	// Type 是 Go 类型的全名
+	Type    string
+	Table   string
+	Column  string
+	Warning bool
+	Message string
+}
+
+func (issue SchemaIssue) String() string {
+	var level = "error"
+	if issue.Warning {
+		level = "warning"
+	}
+	var where = issue.Table
+	if issue.Column != "" {
+		where += "." + issue.Column
+	}
+	return fmt.Sprintf("%s: %s (%s): %s", level, where, issue.Type, issue.Message)
+}
+
+// SchemaReport 是 Validate 的结果
+type SchemaReport struct {
+	Issues []SchemaIssue
+}
+
+// OK 在没有 Warning 以外的问题时返回 true
+func (r *SchemaReport) OK() bool {
+	for _, issue := range r.Issues {
+		if !issue.Warning {
+			return false
+		}
+	}
+	return true
+}
+
+// Err 在 OK 为 false 时返回一个列出全部问题的 error ，否则返回 nil
+func (r *SchemaReport) Err() error {
+	if r.OK() {
+		return nil
+	}
+	return errors.New("schema validation failed:\n" + r.String())
+}
+
+func (r *SchemaReport) String() string {
+	var lines = make([]string, 0, len(r.Issues))
+	for _, issue := range r.Issues {
+		lines = append(lines, issue.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Validate 把所有注册的类型跟数据库中的实际结构做比较：表是否存在，每个 field 对应的字段是否存在、
+// 类型是否兼容，主键是否一致，以及非指针字段对应的列是否可能是 NULL （那样 Scan 会出错）。
+// MapStructTo 注册的类型还会检查数据库中有没有未映射或者没有标记 pk 的主键，以及未映射、又不能为空、
+// 也没有默认值的字段（那样 Insert 会出错）；RegistStruct 注册的类型只检查它声明的字段。
+// 返回的 error 只表示查询数据库失败，结构上的问题都在 SchemaReport 里
+func (e *Engine) Validate() (*SchemaReport, error) {
+	var types = make([]reflect.Type, 0, len(e.gomap))
+	for typ := range e.gomap {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return fullGoName(types[i]) < fullGoName(types[j]) })
+	var report = &SchemaReport{}
+	for _, typ := range types {
+		var table = e.gomap[typ]
+		columns, err := e.liveColumns("Validate", table.tablename)
+		if err != nil {
+			return nil, err
+		}
+		var full = e.tablemap[table.tablename] == table
+		report.Issues = append(report.Issues, table.validate(columns, full)...)
+	}
+	return report, nil
+}
+
+// validate 检查一个类型， full 表示它是 MapStructTo 注册的完整的表
+func (dbt *DbTable) validate(live map[string]*column, full bool) []SchemaIssue {
+	var typename = fullGoName(*dbt.gotype)
+	var issues = make([]SchemaIssue, 0)
+	var report = func(column string, warning bool, format string, args ...interface{}) {
+		issues = append(issues, SchemaIssue{Type: typename, Table: dbt.tablename, Column: column,
+			Warning: warning, Message: fmt.Sprintf(format, args...)})
+	}
+	if len(live) == 0 {
+		report("", false, "table does not exist")
+		return issues
+	}
+	var mapped = make(map[string]bool)
+	for _, field := range dbt.ordered {
+		var fldTyp = field.field
+		mapped[field.DbName] = true
+		var col, exists = live[field.DbName]
+		if !exists {
+			report(field.DbName, false, "column of field %s does not exist", field.GoName)
+			continue
+		}
+		if !typeCompatible(fldTyp.Type, field, col.typ) {
+			report(field.DbName, false, "field %s of type %v is not compatible with column type %s",
+				field.GoName, fldTyp.Type, col.typ)
+		}
+		if field.IsPK && !col.pk {
+			report(field.DbName, false, "field %s is tagged pk but the column is not in the primary key", field.GoName)
+		} else if full && col.pk && !field.IsPK {
+			// 少了主键字段， Update 和 Delete 的条件就不完整，会改到别的行
+			report(field.DbName, false, "column is in the primary key but field %s is not tagged pk", field.GoName)
+		}
+		// 主键列总是有值的，SQLite 的主键列却不一定声明了 NOT NULL
+		var nullable = !field.NotNull || dbdriver.PgNullable(fldTyp.Type)
+		if !nullable && !col.notNull && !col.pk {
+			report(field.DbName, false, "column is nullable but field %s of type %v can't hold NULL",
+				field.GoName, fldTyp.Type)
+		} else if nullable && col.notNull && !col.pk {
+			report(field.DbName, true, "column is NOT NULL but field %s of type %v is nullable",
+				field.GoName, fldTyp.Type)
+		}
+	}
+	if !full {
+		return issues
+	}
+	var unmapped = make([]string, 0)
+	for name := range live {
+		if !mapped[name] {
+			unmapped = append(unmapped, name)
+		}
+	}
+	sort.Strings(unmapped)
+	for _, name := range unmapped {
+		var col = live[name]
+		if col.pk {
+			report(name, false, "primary key column is not mapped")
+		} else if col.notNull && !col.def.Valid && !col.identity {
+			report(name, true, "column is NOT NULL without a default but is not mapped, inserts will fail")
+		}
+	}
+	return issues
+}
+
+// typeCompatible 粗略地判断 Go 类型能不能跟字段类型互相转换，只排除明显不对的组合。
+// 无法识别的数据库类型（枚举、自定义类型等）都算兼容
+func typeCompatible(typ reflect.Type, field *DbField, dbtype string) bool {
+	var db = dbCategory(dbtype)
+	if db == "any" {
+		return true
+	}
+	var allowed []string
+	switch goCategory(typ, field) {
+	case "int":
+		allowed = []string{"int", "numeric"}
+	case "float", "numeric":
+		allowed = []string{"float", "int", "numeric", "text"}
+	case "bool":
+		allowed = []string{"bool"}
+	case "time":
+		allowed = []string{"time"}
+	case "json":
+		allowed = []string{"json", "text", "bytes"}
+	case "uuid":
+		allowed = []string{"uuid", "text", "bytes"}
+	case "bytes":
+		allowed = []string{"bytes", "text", "json", "uuid"}
+	case "array":
+		allowed = []string{"array"}
+	default:
+		// 字符串和无法识别的 Go 类型什么都能接
+		return true
+	}
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		// SQLite 的 bool 存成整数，数组只能当文本存
+		allowed = append(allowed, map[string][]string{
+			"bool":  {"int", "numeric"},
+			"array": {"text", "json"},
+		}[goCategory(typ, field)]...)
+	}
+	for _, a := range allowed {
+		if a == db {
+			return true
+		}
+	}
+	return false
+}
+
+// goCategory 给出 Go 类型的大类
+func goCategory(typ reflect.Type, field *DbField) string {
+	if field.IsJson {
+		return "json"
+	}
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if dbdriver.PgNullable(typ) {
+		for i := 0; i < typ.NumField(); i++ {
+			if typ.Field(i).Name != "Valid" {
+				return goCategory(typ.Field(i).Type, &DbField{})
+			}
+		}
+	}
+	switch typ.Name() {
+	case "Time":
+		if typ.PkgPath() == "time" {
+			return "time"
+		}
+	case "UUID":
+		return "uuid"
+	case "Decimal", "Rat", "Numeric":
+		return "numeric"
+	}
+	switch typ.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Bool:
+		return "bool"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			if typ.Kind() == reflect.Array && typ.Len() == 16 {
+				return "uuid"
+			}
+			return "bytes"
+		}
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "json"
+	}
+	return "other"
+}
+
+// dbCategory 给出数据库字段类型的大类，SQLite 按它的类型亲和性规则判断
+func dbCategory(dbtype string) string {
+	var typ = canonicalType(dbtype)
+	if dbdriver.Sqltype == dbdriver.DB_SQLITE {
+		switch {
+		case typ == "" || strings.Contains(typ, "blob"):
+			return "any"
+		case strings.Contains(typ, "date") || strings.Contains(typ, "time"):
+			return "time"
+		case strings.Contains(typ, "bool"):
+			return "bool"
+		case strings.Contains(typ, "int"):
+			return "int"
+		case strings.Contains(typ, "json"):
+			return "json"
+		case strings.Contains(typ, "char") || strings.Contains(typ, "clob") || strings.Contains(typ, "text"):
+			return "text"
+		case strings.Contains(typ, "real") || strings.Contains(typ, "floa") || strings.Contains(typ, "doub"):
+			return "float"
+		}
+		return "numeric"
+	}
+	if strings.HasSuffix(typ, "[]") {
+		return "array"
+	}
+	if idx := strings.Index(typ, "("); idx >= 0 {
+		typ = typ[:idx]
+	}
+	switch typ {
+	case "smallint", "integer", "bigint":
+		return "int"
+	case "numeric":
+		return "numeric"
+	case "real", "double precision":
+		return "float"
+	case "text", "character varying", "character", "citext", "name":
+		return "text"
+	case "boolean":
+		return "bool"
+	case "date", "timestamp with time zone", "timestamp without time zone",
+		"time with time zone", "time without time zone":
+		return "time"
+	case "bytea":
+		return "bytes"
+	case "json", "jsonb":
+		return "json"
+	case "uuid":
+		return "uuid"
+	}
+	return "any"
+}